@@ -2,84 +2,70 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"strings"
-)
 
-var ErrorLexemes []*Lexeme
-var ErrorStrings []string
+	"github.com/Rex--/mkasm/pal"
+)
 
-func (l *Lexer) UnknownLexeme(lm *Lexeme, col int, msg string) {
-	if col < 0 {
-		col = lm.Col
+func printErrors(diags []pal.Diagnostic, args *CLIArgs) {
+	if args.DiagFormat == "json" {
+		// One object per line, on stderr so they aren't mixed with the
+		// status messages and output written to stdout
+		enc := json.NewEncoder(os.Stderr)
+		for _, d := range diags {
+			d.Message = d.FullMessage()
+			enc.Encode(d)
+		}
+		return
 	}
-	fmt.Print(formatErrorMsg("unknown lexeme: " + msg))
-	fmt.Printf("%3d | %s\n    | %*s\n\n", lm.Line, strings.TrimRight(string(l.line), "\n\r"), col, "^")
-	os.Exit(1)
-}
-
-func (p *Parser) SyntaxError(lm *Lexeme, msg string) {
-	ErrorLexemes = append(ErrorLexemes, lm)
-	ErrorStrings = append(ErrorStrings, "syntax error: "+msg)
-}
-
-func (p *Parser) IllegalReferenceError(lm *Lexeme, msg string) {
-	ErrorLexemes = append(ErrorLexemes, lm)
-	ErrorStrings = append(ErrorStrings, "illegal reference: "+msg)
-}
-
-func (p *Parser) UndefinedSymbolError(lm *Lexeme, msg string) {
-	ErrorLexemes = append(ErrorLexemes, lm)
-	ErrorStrings = append(ErrorStrings, "undefined symbol: "+msg)
-}
-
-func (p *Parser) UndefinedSymbols() {
-	for _, l := range p.undef {
-		ErrorLexemes = append(ErrorLexemes, &l)
-		ErrorStrings = append(ErrorStrings, "undefined symbol")
+	for _, d := range diags {
+		fmt.Print(formatErrorMsg(d))
+		printLine(d, args.ErrCtx)
 	}
 }
 
-func (p *Parser) ResetErrors() {
-	ErrorLexemes = make([]*Lexeme, 0)
-	ErrorStrings = make([]string, 0)
-}
-
-func (p *Parser) HasErrors() bool {
-	return len(ErrorLexemes) > 0
-}
-
-func (p *Parser) PrintErrors() {
-	for i := 0; i < len(ErrorLexemes); i++ {
-		lexemeStr := string(ErrorLexemes[i].Bytes)
-		fmt.Print(formatErrorMsg(ErrorStrings[i] + ": '" + lexemeStr + "'"))
-		printLine(p.lex.ferr, ErrorLexemes[i], p.lex.args.ErrCtx)
+func hasErrors(diags []pal.Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == pal.SevError {
+			return true
+		}
 	}
+	return false
 }
 
-func formatErrorMsg(msg string) string {
-	return fmt.Sprintf("****> Error: %s\n", msg)
+func formatErrorMsg(d pal.Diagnostic) string {
+	msg := d.FullMessage()
+	sev := d.Severity.String()
+	sev = strings.ToUpper(sev[:1]) + sev[1:]
+	return fmt.Sprintf("****> %s: %s:%d:%d: %s [%s]\n", sev, d.File, d.Line, d.Col, msg, d.Code)
 }
 
-func printLine(f *os.File, lm *Lexeme, ctx int) {
-	f.Seek(0, 0)
+func printLine(d pal.Diagnostic, ctx int) {
+	f, err := os.Open(d.File)
+	if err != nil {
+		fmt.Println()
+		return // Nothing to show
+	}
+	defer f.Close()
 	lineReader := bufio.NewReader(f)
-	for i := 1; i < lm.Line; i++ {
+	for i := 1; i < d.Line; i++ {
 		pl, err := lineReader.ReadString('\n')
 		if err != nil && err != io.EOF {
 			panic(err)
 		}
-		if i >= lm.Line-ctx { // Print surrounding context
-			fmt.Printf("%3d | %s\n", lm.Line-(lm.Line-i), strings.TrimRight(pl, "\n\r"))
+		if i >= d.Line-ctx { // Print surrounding context
+			fmt.Printf("%3d | %s\n", d.Line-(d.Line-i), strings.TrimRight(pl, "\n\r"))
 		}
 	}
 	errLine, err := lineReader.ReadString('\n')
 	if err != nil && err != io.EOF {
 		panic(err)
 	}
-	fmt.Printf("%3d | %s\n      %*s%s\n", lm.Line, strings.TrimRight(errLine, "\n\r"), lm.Col, "^", strings.Repeat("~", len(lm.Bytes)-1))
+	fmt.Printf("%3d | %s\n      %*s%s\n", d.Line, strings.TrimRight(errLine, "\n\r"), d.Col, "^", strings.Repeat("~", d.Length-1))
 
 	for i := 1; i <= ctx; i++ {
 		pl, err := lineReader.ReadString('\n')
@@ -87,7 +73,7 @@ func printLine(f *os.File, lm *Lexeme, ctx int) {
 			panic(err)
 		}
 		// Print surrounding context
-		fmt.Printf("%3d | %s\n", lm.Line+i, strings.TrimRight(pl, "\n\r"))
+		fmt.Printf("%3d | %s\n", d.Line+i, strings.TrimRight(pl, "\n\r"))
 	}
 	fmt.Println()
 }
@@ -1,3 +1,3 @@
-module mkasm
+module github.com/Rex--/mkasm
 
 go 1.19
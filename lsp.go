@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Rex--/mkasm/pal"
+)
+
+// Language server for PAL sources speaking the Language Server Protocol over
+// stdio. Documents are assembled when they are opened and saved, the results
+// are used for diagnostics, go to definition of labels, hover and completion.
+type lspServer struct {
+	args *CLIArgs
+	in   *bufio.Reader
+	out  io.Writer
+
+	docs      map[string]string       // Text of each open document by URI
+	results   map[string]*pal.Program // Last assembly of each document by URI
+	published map[string]bool         // URIs that have diagnostics shown
+
+	shutdown bool
+}
+
+// A JSON-RPC request or notification from the client
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
+type rpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   rpcError        `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// JSON-RPC error code for requests that aren't supported
+const rpcMethodNotFound = -32601
+
+// Protocol types, only the fields that are used
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+type lspHover struct {
+	Contents struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	} `json:"contents"`
+	Range lspRange `json:"range"`
+}
+
+// Serve the language server on stdio until the client exits
+func serveLSP(args *CLIArgs) {
+	s := &lspServer{
+		args:      args,
+		in:        bufio.NewReader(os.Stdin),
+		out:       os.Stdout,
+		docs:      make(map[string]string),
+		results:   make(map[string]*pal.Program),
+		published: make(map[string]bool),
+	}
+	for {
+		body, err := s.readMessage()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(os.Stderr, "lsp:", err)
+			}
+			os.Exit(1)
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			fmt.Fprintln(os.Stderr, "lsp: invalid message:", err)
+			continue
+		}
+		if req.Method == "exit" {
+			if s.shutdown {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		s.handle(&req)
+	}
+}
+
+// Read the body of the next message. Messages have headers like HTTP, only
+// Content-Length is used.
+func (s *lspServer) readMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, val, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(val))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %s", val)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(s.in, body)
+	return body, err
+}
+
+func (s *lspServer) send(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *lspServer) respond(id json.RawMessage, result interface{}) {
+	s.send(rpcResponse{"2.0", id, result})
+}
+
+func (s *lspServer) notify(method string, params interface{}) {
+	s.send(rpcNotification{"2.0", method, params})
+}
+
+// Handle a request or notification. Requests have an ID and always get a
+// response.
+func (s *lspServer) handle(req *rpcRequest) {
+	var params lspDocumentParams
+	json.Unmarshal(req.Params, &params)
+	uri := params.TextDocument.URI
+
+	switch req.Method {
+	case "initialize":
+		s.respond(req.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    1, // Full text
+					"save":      true,
+				},
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{"name": "mkasm"},
+		})
+
+	case "shutdown":
+		s.shutdown = true
+		s.respond(req.ID, nil)
+
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+		s.check(uri)
+
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+
+	case "textDocument/didSave":
+		s.check(uri)
+
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		delete(s.results, uri)
+
+	case "textDocument/definition":
+		s.respond(req.ID, s.definition(uri, params.Position))
+
+	case "textDocument/hover":
+		s.respond(req.ID, s.hover(uri, params.Position))
+
+	case "textDocument/completion":
+		s.respond(req.ID, s.completion())
+
+	default:
+		if req.ID != nil {
+			s.send(rpcErrorResponse{"2.0", req.ID, rpcError{rpcMethodNotFound, "method not found: " + req.Method}})
+		}
+	}
+}
+
+// Get the symbol table selected by the options, with the symbols defined on
+// the command line
+func (s *lspServer) symbols() *pal.SymbolTable {
+	st := s.args.CPU.Symbols.Copy()
+	for name, val := range s.args.Defines {
+		st.Set(name, val)
+	}
+	return st
+}
+
+// Assemble a document and publish its diagnostics
+func (s *lspServer) check(uri string) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return
+	}
+	prog, diags := pal.Assemble(strings.NewReader(s.docs[uri]), pal.Options{
+		Name:    path,
+		CPU:     s.args.CPU,
+		PalD:    s.args.LangPalD,
+		Links:   s.args.Links,
+		FPP12:   s.args.FPP12,
+		Defines: s.args.Defines,
+	})
+	if prog == nil {
+		return
+	}
+	s.results[uri] = prog
+
+	// Diagnostics can be in included files, publish them for each file and
+	// clear the files that no longer have any
+	byURI := make(map[string][]lspDiagnostic)
+	byURI[uri] = []lspDiagnostic{}
+	for _, d := range diags {
+		fileURI := uri
+		if d.File != path {
+			fileURI = pathToURI(d.File)
+		}
+		byURI[fileURI] = append(byURI[fileURI], lspDiagnostic{
+			Range:    lspSpan(d.Line, d.Col, d.Length),
+			Severity: int(d.Severity) + 1, // Error, Warning, Information
+			Code:     d.Code,
+			Source:   "mkasm",
+			Message:  d.FullMessage(),
+		})
+	}
+	for fileURI := range s.published {
+		if _, ok := byURI[fileURI]; !ok {
+			byURI[fileURI] = []lspDiagnostic{}
+		}
+	}
+	for fileURI, diags := range byURI {
+		s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         fileURI,
+			"diagnostics": diags,
+		})
+		if len(diags) > 0 {
+			s.published[fileURI] = true
+		} else {
+			delete(s.published, fileURI)
+		}
+	}
+}
+
+// Get the location of the label under the cursor
+func (s *lspServer) definition(uri string, pos lspPosition) interface{} {
+	name, _ := s.wordAt(uri, pos)
+	prog := s.results[uri]
+	if name == "" || prog == nil {
+		return nil
+	}
+	lm, ok := prog.Defs[name]
+	if sym := prog.Symbols.Get(name); !ok || sym == nil || sym.Type != pal.LABEL {
+		return nil
+	}
+	return lspLocation{pathToURI(lm.File), lspSpan(lm.Line, lm.Col, len(lm.Bytes))}
+}
+
+// Show the value and type of the symbol under the cursor
+func (s *lspServer) hover(uri string, pos lspPosition) interface{} {
+	name, start := s.wordAt(uri, pos)
+	if name == "" {
+		return nil
+	}
+	st := s.symbols()
+	if prog := s.results[uri]; prog != nil {
+		st = prog.Symbols
+	}
+	sym := st.Get(name)
+	if sym == nil {
+		return nil
+	}
+	var h lspHover
+	h.Contents.Kind = "markdown"
+	h.Contents.Value = fmt.Sprintf("`%s` = `%.4o` (%s)", name, sym.Val, sym.Type)
+	h.Range = lspRange{lspPosition{pos.Line, start}, lspPosition{pos.Line, start + len(name)}}
+	return h
+}
+
+// List the instruction mnemonics of the active symbol table
+func (s *lspServer) completion() []lspCompletionItem {
+	st := s.symbols()
+	names := make([]string, 0, len(*st))
+	for name, sym := range *st {
+		if sym.Type == pal.SI || sym.Type == pal.MRI {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	items := make([]lspCompletionItem, 0, len(names))
+	for _, name := range names {
+		sym := (*st)[name]
+		items = append(items, lspCompletionItem{
+			Label:  name,
+			Kind:   14, // Keyword
+			Detail: fmt.Sprintf("%s %.4o", sym.Type, sym.Val),
+		})
+	}
+	return items
+}
+
+// Get the symbol at a position in a document and the character it starts at
+func (s *lspServer) wordAt(uri string, pos lspPosition) (string, int) {
+	lines := strings.Split(s.docs[uri], "\n")
+	if pos.Line < 0 || pos.Line >= len(lines) {
+		return "", 0
+	}
+	line := lines[pos.Line]
+	if pos.Character < 0 || pos.Character > len(line) {
+		return "", 0
+	}
+	start, end := pos.Character, pos.Character
+	for start > 0 && isSymbolChar(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isSymbolChar(line[end]) {
+		end++
+	}
+	if !pal.IsSymbolName(line[start:end]) {
+		return "", 0
+	}
+	return line[start:end], start
+}
+
+// Check if a character can be part of a symbol
+func isSymbolChar(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9')
+}
+
+// Get the range of a span in a line. Lines and columns start at 1 in mkasm but
+// at 0 in the protocol.
+func lspSpan(line, col, length int) lspRange {
+	return lspRange{
+		lspPosition{line - 1, col - 1},
+		lspPosition{line - 1, col - 1 + length},
+	}
+}
+
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI: %s", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+
+	"github.com/Rex--/mkasm/pal"
 )
 
 type CLIArgs struct {
 	ProgName  string
 	InFile    string
+	InFiles   []string
 	OutFile   string
 	CustomExt bool
 
@@ -18,24 +22,74 @@ type CLIArgs struct {
 	LangPal3 bool
 	LangPalD bool
 	LangMK   bool
+	CPU      *pal.CPUModel
+
+	// Print the symbol table in this format (text or json) and exit
+	DumpSymtab string
 
 	Pobj bool
 	Ihex bool
 	Rim  bool
 	Bin  bool
 	URL  bool
+	Rel  bool
 
 	CustomBaseURL string
 
 	Listing bool
+	Xref    bool
 	Dump    bool
 	Size    bool
 
-	ErrCtx int
+	ErrCtx     int
+	DiagFormat string
+
+	// Generate links for off-page references
+	Links bool
+
+	// Store FLTG numbers in the FPP-12 extended precision format
+	FPP12 bool
+
+	// Symbols defined on the command line
+	Defines map[string]int
+
+	// Disassembler options
+	Disasm bool
+
+	// Run the language server
+	LSP bool
+
+	// Link relocatable objects instead of assembling
+	Link bool
+	// Bundle relocatable objects into a library
+	Lib bool
+
+	// Simulator options
+	Run      bool
+	SR       int
+	Start    int
+	MaxSteps int
+}
+
+// Command line flag that can be given more than once
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(val string) error {
+	*sl = append(*sl, val)
+	return nil
 }
 
 func printUsage() {
-	fmt.Println("Usage:", os.Args[0], "[options] <src_file> [out_file]")
+	fmt.Println("Usage:", os.Args[0], "[options] <src_file>... [out_file]")
+	fmt.Println("      ", os.Args[0], "run [options] <src_file>...")
+	fmt.Println("      ", os.Args[0], "link [options] <rel_file|lib_file>... [out_file]")
+	fmt.Println("      ", os.Args[0], "lib [options] <rel_file|lib_file>... <lib_file>")
+	fmt.Println("      ", os.Args[0], "disasm [options] <bin_file> [out_file]")
+	fmt.Println("      ", os.Args[0], "lsp [options]")
 	fmt.Printf("\nOptions:\n")
 	flag.PrintDefaults()
 }
@@ -49,37 +103,219 @@ func parseArgs() CLIArgs {
 
 	flag.Usage = printUsage
 
+	// Check for a subcommand before the options
+	cmdArgs := os.Args[1:]
+	if len(cmdArgs) > 0 {
+		switch cmdArgs[0] {
+		case "run":
+			args.Run = true
+			cmdArgs = cmdArgs[1:]
+		case "disasm":
+			args.Disasm = true
+			cmdArgs = cmdArgs[1:]
+		case "lsp":
+			args.LSP = true
+			cmdArgs = cmdArgs[1:]
+		case "link":
+			args.Link = true
+			cmdArgs = cmdArgs[1:]
+		case "lib":
+			args.Lib = true
+			cmdArgs = cmdArgs[1:]
+		}
+	}
+
 	// Add flags
 	// flag.BoolVar(&args.LangPal3, "3", true, "Only support PAL-III syntax")
 	flag.BoolVar(&args.LangPalD, "D", false, "Support additional PAL-D syntax")
 	flag.BoolVar(&args.Pobj, "pobj", false, "Output in PObject (.po) format")
 	flag.BoolVar(&args.Rim, "rim", false, "Output in RIM format")
+	flag.BoolVar(&args.Bin, "bin", false, "Output in BIN format")
+	flag.BoolVar(&args.Ihex, "ihex", false, "Output in Intel HEX format")
 	flag.BoolVar(&args.URL, "url", false, "Output in URL format")
+	flag.BoolVar(&args.Rel, "rel", false, "Output a relocatable object (.rel) for linking")
 	flag.BoolVar(&args.Dump, "dump", false, "Dump program listing to stdout")
 	flag.BoolVar(&args.Listing, "list", false, "Generate program listing file")
+	flag.BoolVar(&args.Xref, "xref", false, "Add a cross-reference of symbols to the listing")
 	flag.BoolVar(&args.Size, "size", false, "Print program size information")
-	flag.BoolVar(&args.LangMK, "mk", false, "Use alternate MK symbol table")
+	flag.BoolVar(&args.Links, "links", false, "Generate links for off-page references")
+	flag.BoolVar(&args.FPP12, "fpp12", false, "Store FLTG numbers in the FPP-12 extended precision format (6 words)")
+	flag.BoolVar(&args.LangMK, "mk", false, "Use alternate MK symbol table, same as -cpu MK-12")
+	symtabFile := flag.String("symtab", "", "Load symbols from a text or JSON (.json) symbol table file")
+	symtabReplace := flag.Bool("symtab-replace", false, "Use only the symbols from -symtab instead of adding them to the built-in ones")
+	flag.StringVar(&args.DumpSymtab, "dump-symtab", "", "Print the symbol table as text or json and exit")
+	cpuName := flag.String("cpu", "8/E", "CPU model: PDP-8, 8/I, 8/E, 8/A or MK-12")
 	flag.IntVar(&args.ErrCtx, "err-ctx", 0, "Lines of context surrounding errors")
+	flag.StringVar(&args.DiagFormat, "diag-format", "text", "Format of errors and warnings, text or json")
 	flag.StringVar(&args.CustomBaseURL, "url-base", "", "Base URL to use for URL format.")
+	sr := flag.String("sr", "0", "Switch register value in octal (run)")
+	start := flag.String("start", "200", "Start address in octal (run)")
+	flag.IntVar(&args.MaxSteps, "max-steps", 0, "Stop after this many instructions, 0 for no limit (run)")
+	var defines stringList
+	flag.Var(&defines, "d", "Define a symbol as `NAME=value` (octal), can be repeated")
+	outArg := flag.String("o", "", "Output file, every positional argument is a source file")
 	help := flag.Bool("help", false, "Print this message and exit")
 
 	// Parse
-	flag.Parse()
+	flag.CommandLine.Parse(cmdArgs)
 
 	if *help {
 		flag.Usage()
 		os.Exit(0)
 	}
 
-	// Get remaining positional arguments (infile [outfile])
-	if len(flag.Args()) == 1 {
+	// Parse simulator addresses
+	for _, opt := range []struct {
+		str *string
+		val *int
+	}{{sr, &args.SR}, {start, &args.Start}} {
+		val, err := strconv.ParseInt(*opt.str, 8, 16)
+		if err != nil || val > 0o77777 {
+			fmt.Println("Invalid octal value:", *opt.str)
+			os.Exit(1)
+		}
+		*opt.val = int(val)
+	}
+
+	// Select the CPU model
+	args.CPU = pal.FindCPU(*cpuName)
+	if args.CPU == nil {
+		fmt.Println("Unknown CPU model:", *cpuName)
+		os.Exit(1)
+	}
+	if args.LangMK {
+		if isFlagSet("cpu") && !args.CPU.SwapIR {
+			fmt.Println("-mk can't be used with -cpu", *cpuName)
+			os.Exit(1)
+		}
+		args.CPU = pal.FindCPU("MK-12")
+	}
+	args.LangMK = args.CPU.SwapIR
+
+	// Load a symbol table file into a copy of the CPU's symbol table
+	if *symtabFile != "" {
+		cpu := *args.CPU
+		cpu.Symbols = cpu.Symbols.Copy()
+		if *symtabReplace {
+			cpu.Symbols = &pal.SymbolTable{}
+		}
+		f, err := os.Open(*symtabFile)
+		if err != nil {
+			panic(err)
+		}
+		err = cpu.Symbols.ImportSymbols(f, strings.ToLower(path.Ext(*symtabFile)) == ".json")
+		f.Close()
+		if err != nil {
+			fmt.Println("****> Error:", *symtabFile+":", err)
+			os.Exit(1)
+		}
+		args.CPU = &cpu
+	} else if *symtabReplace {
+		fmt.Println("-symtab-replace needs a symbol table file (-symtab)")
+		os.Exit(1)
+	}
+	if args.DumpSymtab != "" && args.DumpSymtab != "text" && args.DumpSymtab != "json" {
+		fmt.Println("Unknown symbol table format:", args.DumpSymtab)
+		os.Exit(1)
+	}
+
+	if args.DiagFormat != "text" && args.DiagFormat != "json" {
+		fmt.Println("Unknown diagnostic format:", args.DiagFormat)
+		os.Exit(1)
+	}
+
+	// Parse symbol definitions, a symbol without a value is defined as 1
+	args.Defines = make(map[string]int)
+	for _, def := range defines {
+		name, valStr, found := strings.Cut(def, "=")
+		if !found {
+			valStr = "1"
+		}
+		val, err := strconv.ParseInt(valStr, 8, 16)
+		if err != nil || val > 0o7777 || !pal.IsSymbolName(name) {
+			fmt.Println("Invalid symbol definition:", def)
+			os.Exit(1)
+		}
+		args.Defines[name] = int(val)
+	}
+
+	// Get remaining positional arguments (infile... [outfile])
+	if args.DumpSymtab != "" {
+		// Nothing is assembled
+		return args
+	} else if args.LSP {
+		// Sources are opened by the editor
+		if len(flag.Args()) != 0 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		return args
+	} else if args.Run {
+		if len(flag.Args()) < 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
 		args.InFile = flag.Arg(0)
-		// Get outfile based on in file
-		args.OutFile = strings.TrimSuffix(flag.Arg(0), path.Ext(flag.Arg(0)))
-	} else if len(flag.Args()) == 2 {
+		args.InFiles = flag.Args()
+		// Nothing is written when running a program
+		return args
+	} else if args.Disasm {
+		if len(flag.Args()) < 1 || len(flag.Args()) > 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
 		args.InFile = flag.Arg(0)
+		args.OutFile = flag.Arg(1) // Empty writes to stdout
+		// Get the input format from the extension if it wasn't given
+		if !args.Pobj && !args.Rim && !args.Bin && !args.Ihex {
+			switch strings.ToLower(path.Ext(args.InFile)) {
+			case ".rim", ".rm":
+				args.Rim = true
+			case ".bin", ".bn":
+				args.Bin = true
+			case ".hex", ".ihx":
+				args.Ihex = true
+			case ".pobj", ".po":
+				args.Pobj = true
+			default:
+				fmt.Println("Unknown input format, use -pobj, -rim, -bin or -ihex")
+				os.Exit(1)
+			}
+		}
+		return args
+	} else if len(flag.Args()) >= 1 {
+		args.InFiles = flag.Args()
+		// The last argument is the out file unless it is a source file, or an
+		// object file when linking. Libraries can be read into a library so
+		// its out file is always the last argument.
+		isInFile := isSourceFile
+		if args.Link {
+			isInFile = isObjectFile
+		}
+		last := flag.Arg(len(flag.Args()) - 1)
+		if args.Lib && *outArg == "" {
+			if len(flag.Args()) < 2 || strings.ToLower(path.Ext(last)) != ".lib" {
+				flag.Usage()
+				os.Exit(1)
+			}
+			*outArg = last
+			args.InFiles = args.InFiles[:len(args.InFiles)-1]
+		} else if *outArg == "" && len(flag.Args()) > 1 && !isInFile(last) {
+			*outArg = last
+			args.InFiles = args.InFiles[:len(args.InFiles)-1]
+		}
+		args.InFile = args.InFiles[0]
+	} else {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *outArg == "" {
+		// Get outfile based on in file
+		args.OutFile = strings.TrimSuffix(args.InFile, path.Ext(args.InFile))
+	} else {
 		// Get the extension of the outfile and output in that format if known
-		ext := path.Ext(flag.Arg(1))
+		ext := path.Ext(*outArg)
 		switch ext {
 		case ".rim":
 			fallthrough
@@ -89,7 +325,27 @@ func parseArgs() CLIArgs {
 			fallthrough
 		case ".RM":
 			args.Rim = true
-			args.OutFile = strings.TrimSuffix(flag.Arg(1), ext)
+			args.OutFile = strings.TrimSuffix(*outArg, ext)
+
+		case ".bin":
+			fallthrough
+		case ".bn":
+			fallthrough
+		case ".BIN":
+			fallthrough
+		case ".BN":
+			args.Bin = true
+			args.OutFile = strings.TrimSuffix(*outArg, ext)
+
+		case ".hex":
+			fallthrough
+		case ".ihx":
+			fallthrough
+		case ".HEX":
+			fallthrough
+		case ".IHX":
+			args.Ihex = true
+			args.OutFile = strings.TrimSuffix(*outArg, ext)
 
 		case ".pobj":
 			fallthrough
@@ -97,16 +353,24 @@ func parseArgs() CLIArgs {
 			fallthrough
 		case ".PO":
 			args.Pobj = true
-			args.OutFile = strings.TrimSuffix(flag.Arg(1), ext)
+			args.OutFile = strings.TrimSuffix(*outArg, ext)
+
+		case ".rel":
+			fallthrough
+		case ".REL":
+			args.Rel = true
+			args.OutFile = strings.TrimSuffix(*outArg, ext)
+
+		case ".lib":
+			fallthrough
+		case ".LIB":
+			args.OutFile = strings.TrimSuffix(*outArg, ext)
 
 		default:
 			// Save the extension if we don't recognize it
 			args.CustomExt = true
-			args.OutFile = flag.Arg(1)
+			args.OutFile = *outArg
 		}
-	} else {
-		flag.Usage()
-		os.Exit(1)
 	}
 
 	// Determine if URL flag was provided
@@ -114,8 +378,14 @@ func parseArgs() CLIArgs {
 		args.URL = true
 	}
 
+	// A relocatable object can't be loaded, it has to be linked first
+	if args.Rel && (args.Link || args.Pobj || args.Rim || args.Bin || args.Ihex || args.URL) {
+		fmt.Println("-rel can't be combined with other output formats or link")
+		os.Exit(1)
+	}
+
 	// Set a default output format if we couldn't deduce one
-	if !args.Pobj && !args.Rim && !args.URL && !args.Dump {
+	if !args.Pobj && !args.Rim && !args.Bin && !args.Ihex && !args.URL && !args.Rel && !args.Dump {
 		// Default currently is pobj because it's human readable
 		args.Pobj = true
 	}
@@ -135,26 +405,68 @@ func main() {
 
 	args := parseArgs()
 
-	// Open file
-	srcFile, err := os.Open(args.InFile)
-	if err != nil {
-		panic(err)
+	if args.Disasm {
+		disassemble(&args)
+		return
 	}
-	defer srcFile.Close()
 
-	lexer := NewLexer(srcFile, &args)
-	parser := NewParser(lexer, &default_symbols)
-	if args.LangMK {
-		parser.symtab = &mk_symbols
+	if args.LSP {
+		serveLSP(&args)
+		return
 	}
-	parser.parseP8Assembly()
-	if parser.HasErrors() {
-		srcFile.Close()
-		os.Exit(1)
+
+	if args.DumpSymtab != "" {
+		args.CPU.Symbols.ExportSymbols(os.Stdout, args.DumpSymtab == "json")
+		return
+	}
+
+	if args.Lib {
+		makeLibrary(&args)
+		return
+	}
+
+	// Linking gives a program without a listing
+	var prog *pal.Program
+	var mem pal.Memory
+	if args.Link {
+		mem = linkObjects(&args)
+	} else {
+		var diags []pal.Diagnostic
+		var err error
+		prog, diags, err = pal.AssembleFiles(args.InFiles, pal.Options{
+			CPU:         args.CPU,
+			PalD:        args.LangPalD,
+			Links:       args.Links,
+			FPP12:       args.FPP12,
+			Relocatable: args.Rel,
+			Defines:     args.Defines,
+		})
+		if err != nil {
+			panic(err)
+		}
+		printErrors(diags, &args)
+		if hasErrors(diags) {
+			os.Exit(1)
+		}
+		mem = prog.Mem
+	}
+
+	// Run the program instead of writing it out
+	if args.Run {
+		runProgram(mem, &args)
+		return
 	}
 
-	if args.Dump {
-		parser.mem.exportListing(os.Stdout, parser.listing, parser.tagListing)
+	// Only some formats can hold more than the first memory field
+	if fields := mem.Fields(); (args.Pobj || args.Rim || args.URL) && (len(fields) > 1 || len(fields) == 1 && fields[0] != 0) {
+		fmt.Println("Warning: PObj, RIM and URL formats only contain memory field 0")
+	}
+
+	if args.Dump && prog != nil {
+		prog.ExportListing(os.Stdout)
+		if args.Xref {
+			prog.ExportCrossReference(os.Stdout)
+		}
 	}
 
 	// Open out file
@@ -175,7 +487,7 @@ func main() {
 			panic(err)
 		}
 		fmt.Println("Writing PObj output file:", outPath)
-		parser.mem.exportPObject(outFile)
+		mem.ExportPObject(outFile)
 		outFile.Close()
 	}
 
@@ -189,29 +501,221 @@ func main() {
 			panic(err)
 		}
 		fmt.Println("Writing RIM output file:", outPath)
-		parser.mem.exportRim(outFile)
+		mem.ExportRim(outFile)
+		outFile.Close()
+	}
+
+	if args.Bin {
+		outPath := args.OutFile
+		if !args.CustomExt {
+			outPath += ".bin"
+		}
+		outFile, err := os.Create(outPath)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Writing BIN output file:", outPath)
+		mem.ExportBin(outFile)
+		outFile.Close()
+	}
+
+	if args.Ihex {
+		outPath := args.OutFile
+		if !args.CustomExt {
+			outPath += ".hex"
+		}
+		outFile, err := os.Create(outPath)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Writing Intel HEX output file:", outPath)
+		mem.ExportIntelHex(outFile)
+		outFile.Close()
+	}
+
+	if args.Rel {
+		outPath := args.OutFile
+		if !args.CustomExt {
+			outPath += ".rel"
+		}
+		outFile, err := os.Create(outPath)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println("Writing relocatable object file:", outPath)
+		prog.Object.ExportRel(outFile)
 		outFile.Close()
 	}
 
 	if args.URL {
 		// fmt.Println("Output URL:")
-		parser.mem.exportURL(args.CustomBaseURL)
+		mem.ExportURL(os.Stdout, args.CustomBaseURL)
 	}
 
 	// Generate listing file
-	if args.Listing {
+	if args.Listing && prog != nil {
 		outPath := strings.TrimSuffix(args.InFile, path.Ext(args.InFile)) + ".lst"
 		outFile, err := os.Create(outPath)
 		if err != nil {
 			panic(err)
 		}
 		fmt.Println("Writing program listing:", outPath)
-		parser.mem.exportListing(outFile, parser.listing, parser.tagListing)
+		prog.ExportListing(outFile)
+		if args.Xref {
+			prog.ExportCrossReference(outFile)
+		}
 		outFile.Close()
 	}
 
 	// Print program size
 	if args.Size {
-		parser.mem.exportSize()
+		mem.ExportSize(os.Stdout)
+	}
+}
+
+// Check if a flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Check if a file name has the extension of a relocatable object or library
+// file
+func isObjectFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".rel", ".lib":
+		return true
+	}
+	return false
+}
+
+// Check if a file name has the extension of a PAL source file
+func isSourceFile(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".pa", ".pal", ".p8":
+		return true
+	}
+	return false
+}
+
+// Simulate an assembled program using the console for the teletype
+func runProgram(mem pal.Memory, args *CLIArgs) {
+	cpu := NewCPU(os.Stdin, os.Stdout)
+	cpu.SwapIR = args.LangMK
+	cpu.Load(mem)
+	cpu.SR = args.SR & 0o7777
+	cpu.IF = args.Start >> 12
+	cpu.IB = cpu.IF
+	cpu.PC = args.Start & 0o7777
+	cpu.Run(args.MaxSteps)
+	fmt.Fprintf(os.Stderr, "\nHalted (%s) after %d instructions: %s\n", cpu.Reason, cpu.Steps, cpu)
+}
+
+// Read relocatable object files and link them into one program. Modules are
+// only linked from libraries when they are needed.
+func linkObjects(args *CLIArgs) pal.Memory {
+	objs, libs := readObjects(args.InFiles)
+	mem, err := pal.Link(objs, libs...)
+	if err != nil {
+		fmt.Println("****> Error:", err)
+		os.Exit(1)
+	}
+	return mem
+}
+
+// Bundle relocatable object files into a library. Libraries can be added to
+// another library.
+func makeLibrary(args *CLIArgs) {
+	objs, libs := readObjects(args.InFiles)
+	for _, lib := range libs {
+		objs = append(objs, lib.Modules...)
+	}
+	lib, err := pal.NewLibrary(objs)
+	if err != nil {
+		fmt.Println("****> Error:", err)
+		os.Exit(1)
+	}
+
+	outPath := args.OutFile
+	if !args.CustomExt {
+		outPath += ".lib"
+	}
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("Writing library file:", outPath)
+	lib.ExportLib(outFile)
+	outFile.Close()
+}
+
+// Read relocatable object and library files
+func readObjects(names []string) ([]*pal.Object, []*pal.Library) {
+	var objs []*pal.Object
+	var libs []*pal.Library
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			panic(err)
+		}
+		if strings.ToLower(path.Ext(name)) == ".lib" {
+			var lib *pal.Library
+			lib, err = pal.ImportLib(f)
+			libs = append(libs, lib)
+		} else {
+			var obj *pal.Object
+			obj, err = pal.ImportRel(f)
+			objs = append(objs, obj)
+		}
+		f.Close()
+		if err != nil {
+			fmt.Println("****> Error:", name+":", err)
+			os.Exit(1)
+		}
+	}
+	return objs, libs
+}
+
+// Read a binary file in any supported format and write it as PAL source
+func disassemble(args *CLIArgs) {
+	inFile, err := os.Open(args.InFile)
+	if err != nil {
+		panic(err)
+	}
+	defer inFile.Close()
+
+	var mem pal.Memory
+	switch {
+	case args.Rim:
+		mem, err = pal.ImportRim(inFile)
+	case args.Bin:
+		mem, err = pal.ImportBin(inFile)
+	case args.Ihex:
+		mem, err = pal.ImportIntelHex(inFile)
+	default:
+		mem, err = pal.ImportPObject(inFile)
+	}
+	if err != nil {
+		fmt.Println("****> Error:", args.InFile+":", err)
+		os.Exit(1)
+	}
+
+	d := pal.NewDisassembler(mem, args.CPU.Symbols)
+
+	if args.OutFile == "" {
+		d.ExportSource(os.Stdout)
+		return
+	}
+	outFile, err := os.Create(args.OutFile)
+	if err != nil {
+		panic(err)
 	}
+	fmt.Println("Writing PAL source file:", args.OutFile)
+	d.ExportSource(outFile)
+	outFile.Close()
 }
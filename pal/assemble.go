@@ -0,0 +1,144 @@
+// Package pal assembles PAL source for the PDP-8. It is the assembler behind
+// the mkasm command, which is a thin command line interface over Assemble and
+// the output formats of Memory.
+package pal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options control how a program is assembled
+type Options struct {
+	// Name of the source file, used in diagnostics and listings. Included
+	// files are found relative to its directory.
+	Name string
+
+	// CPU model to assemble for, the PDP-8/E if nil. Its symbol table is
+	// copied so assembling never changes it.
+	CPU *CPUModel
+
+	// Accept PAL-D syntax: strings and characters
+	PalD bool
+
+	// Generate links for off-page references
+	Links bool
+
+	// Assemble a relocatable module for the linker. Code from 0200 up is
+	// moved by whole pages when it is linked, the zero page stays in place.
+	Relocatable bool
+
+	// Store FLTG numbers in the six word extended precision format of the
+	// FPP-12 instead of the three word format of the Floating Point Package
+	FPP12 bool
+
+	// Symbols defined before the source is assembled
+	Defines map[string]int
+
+	// Open an included file. Files are opened from the file system if nil.
+	Open func(name string) (io.ReadCloser, error)
+}
+
+// An assembled program
+type Program struct {
+	// Every word of the program by extended address
+	Mem Memory
+
+	// Source line each word was assembled from, by extended address
+	Listing map[int][]byte
+	// Label of each word that has one, by extended address
+	Tags map[int][]byte
+	// Characters in each word of packed text, by extended address
+	Text map[int]string
+
+	// Symbol table after assembly. It holds the permanent symbols of the CPU
+	// and every symbol defined in the source.
+	Symbols *SymbolTable
+	// Where each symbol was defined in the source
+	Defs map[string]Lexeme
+	// Every use of each symbol in an expression
+	Refs map[string][]Lexeme
+
+	// The module for the linker, if it was assembled as relocatable
+	Object *Object
+
+	// Name of the first source file
+	name string
+}
+
+// Assemble a program read from src. The diagnostics are every problem found
+// in the source, the program is incomplete if any of them is an error.
+func Assemble(src io.Reader, opts Options) (*Program, []Diagnostic) {
+	text, err := io.ReadAll(src)
+	if err != nil {
+		d := Diagnostic{Severity: SevError, File: opts.Name, Message: "cannot read source: " + err.Error()}
+		return nil, []Diagnostic{d}
+	}
+	sources := map[string][]byte{opts.Name: text}
+	return assemble([]string{opts.Name}, sources, &opts)
+}
+
+// Assemble source files in order as if they were one file. The name in the
+// options is ignored. An error is returned if a file can't be read.
+func AssembleFiles(names []string, opts Options) (*Program, []Diagnostic, error) {
+	// Read every file up front so a missing file isn't found halfway through
+	// the first pass
+	sources := make(map[string][]byte)
+	for _, name := range names {
+		text, err := readSource(name, &opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources[name] = text
+	}
+	opts.Name = names[0]
+	prog, diags := assemble(names, sources, &opts)
+	return prog, diags, nil
+}
+
+func assemble(names []string, sources map[string][]byte, opts *Options) (*Program, []Diagnostic) {
+	if opts.CPU == nil {
+		opts.CPU = FindCPU("8/E")
+	}
+	st := opts.CPU.Symbols.Copy()
+	for name, val := range opts.Defines {
+		st.Set(name, val)
+	}
+
+	lexer := NewLexer(names, sources, opts)
+	parser := NewParser(lexer, st)
+	parser.parseP8Assembly()
+
+	prog := &Program{
+		Mem:     parser.mem,
+		Listing: parser.listing,
+		Tags:    parser.tagListing,
+		Text:    parser.text,
+		Symbols: parser.symtab,
+		Defs:    parser.defs,
+		Refs:    parser.refs,
+		name:    opts.Name,
+	}
+	if opts.Relocatable {
+		prog.Object = parser.object(strings.TrimSuffix(filepath.Base(opts.Name), filepath.Ext(opts.Name)))
+	}
+	return prog, parser.Diagnostics()
+}
+
+// Read a source file with the opener in the options
+func readSource(name string, opts *Options) ([]byte, error) {
+	var f io.ReadCloser
+	var err error
+	if opts.Open != nil {
+		f, err = opts.Open(name)
+	} else {
+		f, err = os.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
@@ -0,0 +1,62 @@
+package pal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A model of PDP-8 that programs can be assembled for. Models differ in the
+// permanent symbol table and in which operate instructions they can execute.
+type CPUModel struct {
+	Name    string
+	Symbols *SymbolTable
+
+	// Has the 8/E additions to the operate instructions: BSW, CAM and SWP
+	EFamily bool
+	// IAC can be combined with a rotate, the IAC happens first
+	IACRotate bool
+	// Swaps the MRI opcode bits like the MK-12 instruction decoder
+	SwapIR bool
+}
+
+var cpuModels = []CPUModel{
+	{Name: "PDP-8", Symbols: &default_symbols},
+	{Name: "PDP-8/I", Symbols: &default_symbols, IACRotate: true},
+	{Name: "PDP-8/E", Symbols: &default_symbols, EFamily: true, IACRotate: true},
+	{Name: "PDP-8/A", Symbols: &default_symbols, EFamily: true, IACRotate: true},
+	{Name: "MK-12", Symbols: &mk_symbols, EFamily: true, IACRotate: true, SwapIR: true},
+}
+
+// Find a CPU model by name. The PDP- prefix is optional and case is ignored,
+// so "8/e" is the PDP-8/E. Returns nil if there is no model by that name.
+func FindCPU(name string) *CPUModel {
+	name = strings.ToUpper(name)
+	for i := range cpuModels {
+		if name == cpuModels[i].Name || "PDP-"+name == cpuModels[i].Name {
+			return &cpuModels[i]
+		}
+	}
+	return nil
+}
+
+// Check that an operate instruction can be executed by the CPU. Returns a
+// warning message, or an empty string if it can.
+func (c *CPUModel) checkInstruction(inst int) string {
+	switch oprGroup(inst) {
+	case 1:
+		if inst&0o16 == 0o2 && !c.EFamily {
+			return fmt.Sprintf("BSW is not available on the %s", c.Name)
+		}
+		if inst&0o1 != 0 && inst&0o14 != 0 && !c.IACRotate {
+			return fmt.Sprintf("IAC can't be combined with a rotate on the %s", c.Name)
+		}
+	case 3:
+		if inst&0o220 == 0o220 && !c.EFamily {
+			return fmt.Sprintf("CAM (CLA MQL) is not available on the %s", c.Name)
+		}
+		if inst&0o120 == 0o120 && !c.EFamily {
+			return fmt.Sprintf("SWP (MQA MQL) is not available on the %s", c.Name)
+		}
+	}
+	return ""
+}
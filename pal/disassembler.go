@@ -0,0 +1,205 @@
+package pal
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// Disassembler turns memory back into PAL source using a symbol table in
+// reverse.
+type Disassembler struct {
+	mem    Memory
+	mri    map[int]string // Memory reference instructions by opcode
+	exact  map[int]string // Symbols by value
+	opr    []string       // Operate microinstructions that can be combined
+	symtab *SymbolTable
+	labels map[int]string // Generated labels by extended address
+}
+
+func NewDisassembler(m Memory, st *SymbolTable) *Disassembler {
+	d := &Disassembler{
+		mem:    m,
+		mri:    make(map[int]string),
+		exact:  make(map[int]string),
+		symtab: st,
+		labels: make(map[int]string),
+	}
+
+	// Sort names so the same symbol is picked every time a value has more
+	// than one name
+	names := make([]string, 0, len(*st))
+	for name := range *st {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sym := (*st)[name]
+		switch sym.Type {
+		case MRI:
+			if _, exists := d.mri[sym.Val]; !exists {
+				d.mri[sym.Val] = name
+			}
+		case SI:
+			if _, exists := d.exact[sym.Val]; !exists {
+				d.exact[sym.Val] = name
+			}
+			if sym.Val&0o7000 == 0o7000 && sym.Val != 0o7000 {
+				d.opr = append(d.opr, name)
+			}
+		}
+	}
+
+	// Label every location that is directly referenced
+	for addr, inst := range m {
+		if _, ok := d.mri[inst&0o7000]; ok {
+			target := addr&0o70000 | d.mriAddress(addr, inst)
+			if _, exists := m[target]; exists {
+				d.labels[target] = fmt.Sprintf("L%.4o", target)
+			}
+		}
+	}
+
+	return d
+}
+
+// Get the address referenced by an MRI at the given location (not following
+// indirection)
+func (d *Disassembler) mriAddress(loc, inst int) int {
+	addr := inst & 0o177
+	if inst&0o200 != 0 { // Current page
+		addr |= loc & 0o7600
+	}
+	return addr
+}
+
+// Disassemble a single word at the given location
+func (d *Disassembler) Instruction(loc, inst int) string {
+	// Memory reference instructions. Current page references from page 0
+	// can't be written in PAL, they would assemble as zero page references.
+	if name, ok := d.mri[inst&0o7000]; ok && (inst&0o200 == 0 || loc&0o7600 != 0) {
+		addr := d.mriAddress(loc, inst)
+		operand := fmt.Sprintf("%o", addr)
+		if label, ok := d.labels[loc&0o70000|addr]; ok {
+			operand = label
+		}
+		if inst&0o400 != 0 {
+			return name + " I " + operand
+		}
+		return name + " " + operand
+	}
+
+	// Single instructions
+	if name, ok := d.exact[inst]; ok {
+		return name
+	}
+
+	// Combined operate microinstructions
+	if inst&0o7000 == 0o7000 {
+		if names := d.combine(inst); names != nil {
+			return strings.Join(names, " ")
+		}
+	}
+
+	// Memory extension IOTs take the field as an operand (e.g. CDF 10)
+	if base, field := inst&^0o70, inst&0o70; base&0o770 == 0o200 {
+		operand := fmt.Sprintf(" %o", field)
+		if name, ok := d.exact[base]; ok {
+			return name + operand
+		}
+		cdf, cdfOk := d.exact[base&^0o2]
+		cif, cifOk := d.exact[base&^0o1]
+		if base&0o7 == 0o3 && cdfOk && cifOk {
+			return cdf + " " + cif + operand
+		}
+	}
+
+	return fmt.Sprintf("%.4o", inst)
+}
+
+// Find a combination of operate microinstructions that assembles to inst.
+// Each step picks the microinstruction that covers the most bits that are not
+// covered yet. Returns nil if no combination matches exactly.
+func (d *Disassembler) combine(inst int) []string {
+	var names []string
+	covered := 0o7000
+	for covered != inst {
+		best := ""
+		bestNew := 0
+		for _, name := range d.opr {
+			val := (*d.symtab)[name].Val
+			if val&inst != val {
+				continue // Sets bits that aren't in the instruction
+			}
+			if oprGroup(val) != oprGroup(inst) && val != 0o7200 {
+				continue // Only CLA is shared between groups
+			}
+			newBits := bits.OnesCount(uint(val &^ covered))
+			if newBits > bestNew || (newBits == bestNew && newBits > 0 && val > (*d.symtab)[best].Val) {
+				best = name
+				bestNew = newBits
+			}
+		}
+		if best == "" {
+			return nil
+		}
+		names = append(names, best)
+		covered |= (*d.symtab)[best].Val
+	}
+	return names
+}
+
+// Get the group (1-3) of an operate instruction
+func oprGroup(inst int) int {
+	if inst&0o400 == 0 {
+		return 1
+	} else if inst&0o1 == 0 {
+		return 2
+	}
+	return 3
+}
+
+// Write memory as PAL source. Each line is commented with its address, the
+// stored word and the ASCII character if it is printable.
+func (d *Disassembler) ExportSource(w io.Writer) {
+	keys := make([]int, 0, len(d.mem))
+	for k := range d.mem {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	var lastAddr int = -1
+	var field int = 0
+	for _, addr := range keys {
+		if addr>>12 != field {
+			field = addr >> 12
+			fmt.Fprintf(w, "\nFIELD %o\n", field)
+			lastAddr = -1
+		}
+		if addr != lastAddr+1 {
+			fmt.Fprintf(w, "\n*%.4o\n", addr&0o7777)
+		}
+		inst := d.mem[addr]
+
+		label := ""
+		if l, ok := d.labels[addr]; ok {
+			label = l + ","
+		}
+		line := d.Instruction(addr, inst)
+		if len(line) < 8 {
+			line += "\t\t"
+		} else if len(line) < 16 {
+			line += "\t"
+		}
+		comment := fmt.Sprintf("/ %.4o  %.4o", addr&0o7777, inst)
+		if c := inst & 0o177; inst&^0o377 == 0 && c >= ' ' && c < 0o177 {
+			comment += fmt.Sprintf("  '%c'", c)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", label, line, comment)
+		lastAddr = addr
+	}
+	fmt.Fprintln(w, "\n$")
+}
@@ -0,0 +1,131 @@
+package pal
+
+type Severity int
+
+const (
+	SevError Severity = iota
+	SevWarning
+	SevNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SevWarning:
+		return "warning"
+	case SevNote:
+		return "note"
+	}
+	return "error"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Stable codes that identify each kind of diagnostic
+const (
+	CodeSyntax           = "E001"
+	CodeIllegalReference = "E002"
+	CodeUndefinedSymbol  = "E003"
+	CodeUnknownLexeme    = "E004"
+	CodeOffPageLink      = "W001"
+	CodeUnsupported      = "W002"
+	CodePrevDefinition   = "N001"
+)
+
+// A problem found in the source, located by the span of the lexeme it was
+// found at
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Col      int      `json:"column"`
+	Length   int      `json:"length"`
+	Message  string   `json:"message"`
+	Text     string   `json:"-"` // The lexeme the problem was found at
+}
+
+// Create a diagnostic at the location of a lexeme
+func newDiagnostic(sev Severity, code string, lm *Lexeme, msg string) Diagnostic {
+	length := len(lm.Bytes)
+	if length == 0 || lm.Type == EOL || lm.Type == EOF {
+		length = 1
+	}
+	return Diagnostic{
+		Severity: sev,
+		Code:     code,
+		File:     lm.File,
+		Line:     lm.Line,
+		Col:      lm.Col,
+		Length:   length,
+		Message:  msg,
+		Text:     string(lm.Bytes),
+	}
+}
+
+// Report an unknown lexeme. The lexer skips it and carries on scanning.
+func (l *Lexer) UnknownLexeme(lm *Lexeme, col int, msg string) {
+	d := newDiagnostic(SevError, CodeUnknownLexeme, lm, "unknown lexeme: "+msg)
+	if col >= 0 {
+		d.Col = col
+		d.Length = 1
+	}
+	if l.report != nil {
+		l.report(d)
+	}
+}
+
+func (p *Parser) SyntaxError(lm *Lexeme, msg string) {
+	p.report(newDiagnostic(SevError, CodeSyntax, lm, "syntax error: "+msg))
+}
+
+func (p *Parser) IllegalReferenceError(lm *Lexeme, msg string) {
+	p.report(newDiagnostic(SevError, CodeIllegalReference, lm, "illegal reference: "+msg))
+}
+
+func (p *Parser) UndefinedSymbolError(lm *Lexeme, msg string) {
+	if msg == "" {
+		p.report(newDiagnostic(SevError, CodeUndefinedSymbol, lm, "undefined symbol"))
+	} else {
+		p.report(newDiagnostic(SevError, CodeUndefinedSymbol, lm, "undefined symbol: "+msg))
+	}
+}
+
+func (p *Parser) Warning(lm *Lexeme, code string, msg string) {
+	p.report(newDiagnostic(SevWarning, code, lm, msg))
+}
+
+func (p *Parser) Note(lm *Lexeme, code string, msg string) {
+	p.report(newDiagnostic(SevNote, code, lm, msg))
+}
+
+func (p *Parser) report(d Diagnostic) {
+	p.diags = append(p.diags, d)
+}
+
+func (p *Parser) ResetErrors() {
+	p.diags = nil
+}
+
+func (p *Parser) HasErrors() bool {
+	for _, d := range p.diags {
+		if d.Severity == SevError {
+			return true
+		}
+	}
+	return false
+}
+
+// Get every diagnostic reported in the last pass, in the order they were found
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diags
+}
+
+// Get the message with the lexeme an error was found at
+func (d Diagnostic) FullMessage() string {
+	if d.Severity == SevError && d.Text != "" {
+		return d.Message + ": '" + d.Text + "'"
+	}
+	return d.Message
+}
@@ -0,0 +1,535 @@
+package pal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Memory maps a 15-bit extended address to the 12-bit word stored there. The
+// upper 3 bits of the address select one of the 8 possible 4K memory fields.
+type Memory map[int]int
+
+// Get a sorted list of the memory fields that contain data
+func (m Memory) Fields() []int {
+	var used [8]bool
+	for addr := range m {
+		used[addr>>12] = true
+	}
+	fields := make([]int, 0, 8)
+	for f, u := range used {
+		if u {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// A P Object(.po) file is in the format used by pdpnasm.
+// Each line represents either an origin address (prefixed with 0xF---)
+// or an instruction to be placed in memory at the last specified origin + the offset (lines since)
+func (m Memory) ExportPObject(w io.Writer) {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		if k <= 0o7777 { // Only field 0 can be represented
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+
+	var lastAddr int = -1
+	for _, addr := range keys {
+		// Write change of address line
+		if addr > lastAddr+1 {
+			fmt.Fprintf(w, "%o\n", addr|0b1111000000000000)
+		}
+		// Write instruction word
+		fmt.Fprintf(w, "%o\n", m[addr])
+		// Update last address
+		lastAddr = addr
+	}
+}
+
+// A relocatable object (.rel) file holds a module for the linker as text. The
+// first line names the module, it is followed by a line for each entry and
+// then a line for each word with its address and value in octal. Relocatable
+// entries and words are marked with R, words that hold the address of an
+// external symbol are marked with X and the symbol:
+//
+//	MODULE PRINT
+//	ENTRY PRINT 0200 R
+//	0200 0000
+//	0201 1205
+//	0202 0204 R
+//	0203 0000 X TTYOUT
+func (obj *Object) ExportRel(w io.Writer) {
+	fmt.Fprintf(w, "MODULE %s\n", obj.Name)
+
+	names := make([]string, 0, len(obj.Entries))
+	for name := range obj.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := obj.Entries[name]
+		if entry.Relocatable {
+			fmt.Fprintf(w, "ENTRY %s %.4o R\n", name, entry.Value)
+		} else {
+			fmt.Fprintf(w, "ENTRY %s %.4o\n", name, entry.Value)
+		}
+	}
+
+	keys := make([]int, 0, len(obj.Mem))
+	for k := range obj.Mem {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	for _, addr := range keys {
+		if name, ok := obj.Externals[addr]; ok {
+			fmt.Fprintf(w, "%.4o %.4o X %s\n", addr, obj.Mem[addr], name)
+		} else if obj.Relocs[addr] {
+			fmt.Fprintf(w, "%.4o %.4o R\n", addr, obj.Mem[addr])
+		} else {
+			fmt.Fprintf(w, "%.4o %.4o\n", addr, obj.Mem[addr])
+		}
+	}
+}
+
+// A library (.lib) file holds relocatable modules for the linker as text. It
+// starts with an index of the entries of every module and the module that
+// defines each one, followed by the modules in the relocatable object format:
+//
+//	LIBRARY
+//	INDEX FADD fpp
+//	INDEX PRINT tty
+//	MODULE fpp
+//	...
+//	MODULE tty
+//	...
+func (lib *Library) ExportLib(w io.Writer) {
+	fmt.Fprintln(w, "LIBRARY")
+
+	names := make([]string, 0, len(lib.Index))
+	for name := range lib.Index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "INDEX %s %s\n", name, lib.Index[name])
+	}
+
+	for _, obj := range lib.Modules {
+		obj.ExportRel(w)
+	}
+}
+
+// The read in mode (RIM) format is a binary format originally used for paper tapes.
+// It was the format used for the first bootstrapping programs on the PDP-8.
+// The RIM loader was small enough to be keyed in manually using the switch
+// register, using only 18 memory locations (16 instructions to key in) !
+//
+// The original format conveniently used 8-column tape so we use an 8-bit byte
+// for the smallest block. The basic format is a 2-byte address followed by
+// a 2-byte memory word. In both cases the actual address or data is in the
+// lower 6-bits of each byte.
+//
+// The first byte of the address always has bit 7 set. The following byte
+// representing the lower 6-bits of the address should not have bit 7 set.
+//
+// Both bytes of data should have bits 7 and 8 cleared. Same as the address,
+// the first byte represents the MSB and the 2nd is the LSB.
+//
+// The program consists of these 4-bytes for every word to be programmed in
+// memory. The program can be lead and trailed with zero or more of the
+// leader/trailer byte value 0x80 or 1000 0000 in binary.
+func (m Memory) ExportRim(w io.Writer) {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		if k <= 0o7777 { // Only field 0 can be represented
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+
+	var p []byte
+
+	p = append(p, 0o200, 0o200)
+	for _, addr := range keys {
+		// Add address
+		p = append(p, byte((addr&0o7700)>>6)|0o100, byte(addr&0o77))
+		inst := m[addr]
+		p = append(p, byte((inst&0o7700)>>6), byte(inst&0o77))
+	}
+	p = append(p, 0o200, 0o200)
+	_, err := w.Write(p)
+	if err != nil {
+		panic("Unable to write")
+	}
+}
+
+// The binary (BIN) format is the paper tape format read by DEC's BIN loader.
+// Like RIM it uses 8-column tape with the data in the lower 6-bits of each
+// byte, but origins are only punched when the address is not sequential so
+// tapes are roughly half the length.
+//
+// An origin is 2 bytes with bit 7 set in the first byte. Each data word
+// following an origin is 2 bytes with bits 7 and 8 cleared and is stored in
+// consecutive memory locations starting from the origin.
+//
+// A field setting is a single byte with bits 7 and 8 set and the field in
+// bits 4-6. Following words are loaded into the given field.
+//
+// The last data word on the tape is a checksum: the 12-bit sum of every
+// origin and data byte, not including field settings. The tape is lead and
+// trailed with the leader/trailer byte 0x80, same as RIM.
+func (m Memory) ExportBin(w io.Writer) {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	var p []byte
+	var checksum int
+
+	p = append(p, 0o200, 0o200)
+	var lastAddr int = -1
+	var field int = 0
+	for _, addr := range keys {
+		// Add field setting
+		if addr>>12 != field {
+			field = addr >> 12
+			p = append(p, byte(0o300|field<<3))
+			lastAddr = -1 // Force an origin in the new field
+		}
+		// Add origin
+		if addr != lastAddr+1 {
+			origin := []byte{byte((addr&0o7700)>>6) | 0o100, byte(addr & 0o77)}
+			checksum += int(origin[0]) + int(origin[1])
+			p = append(p, origin...)
+		}
+		inst := m[addr]
+		data := []byte{byte((inst & 0o7700) >> 6), byte(inst & 0o77)}
+		checksum += int(data[0]) + int(data[1])
+		p = append(p, data...)
+		lastAddr = addr
+	}
+	checksum &= 0o7777
+	p = append(p, byte((checksum&0o7700)>>6), byte(checksum&0o77))
+	p = append(p, 0o200, 0o200)
+	_, err := w.Write(p)
+	if err != nil {
+		panic("Unable to write")
+	}
+}
+
+// Intel HEX is a text format commonly used to program EPROMs and FPGA memory.
+// Each 12-bit word is stored as a 16-bit little-endian value, so the byte
+// address of a word is twice its extended memory address.
+//
+// Every line is a record in the form ':LLAAAATT<data>CC' where LL is the
+// number of data bytes, AAAA is the lower 16-bits of the byte address, TT is
+// the record type and CC is the two's complement of the sum of all the bytes
+// in the record. Data records (type 00) hold up to 16 bytes of consecutive
+// memory. Extended linear address records (type 04) set the upper 16-bits of
+// the address for the following data records. The file ends with an end of
+// file record (type 01).
+func (m Memory) ExportIntelHex(w io.Writer) {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	writeRecord := func(addr int, recType byte, data []byte) {
+		record := []byte{byte(len(data)), byte(addr >> 8), byte(addr), recType}
+		record = append(record, data...)
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		fmt.Fprintf(w, ":%X%02X\n", record, -sum)
+	}
+
+	var data []byte
+	var start int
+	var upper int = -1
+	for i, addr := range keys {
+		byteAddr := addr * 2
+		if len(data) == 0 {
+			start = byteAddr
+			// Set upper address bits if they changed
+			if byteAddr>>16 != upper {
+				upper = byteAddr >> 16
+				writeRecord(0, 0x04, []byte{byte(upper >> 8), byte(upper)})
+			}
+		}
+		inst := m[addr]
+		data = append(data, byte(inst&0xff), byte(inst>>8))
+
+		// Write the record when it's full or the next address isn't sequential
+		// or would cross into a new 64K segment
+		last := i == len(keys)-1
+		if last || len(data) == 16 || keys[i+1] != addr+1 || (byteAddr+2)>>16 != upper {
+			writeRecord(start&0xffff, 0x00, data)
+			data = data[:0]
+		}
+	}
+	writeRecord(0, 0x01, nil)
+}
+
+// var urlBase = "http://localhost"
+
+func (m Memory) ExportURL(w io.Writer, urlBase string) {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		if k <= 0o7777 { // Only field 0 can be represented
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+
+	link := urlBase + "?core="
+	var lastAddr int = -1
+	for i, addr := range keys {
+		// Write change of address line
+		if addr > lastAddr+1 {
+			link += fmt.Sprintf("*0%o,", addr)
+		}
+		if i == len(keys)-1 {
+			// Write last instruction word
+			link += fmt.Sprintf("0%o", m[addr])
+		} else {
+			// Write instruction word
+			link += fmt.Sprintf("0%o,", m[addr])
+		}
+		// Update last address
+		lastAddr = addr
+	}
+	fmt.Fprint(w, link)
+}
+
+func (m Memory) exportListing(w io.Writer, lst map[int][]byte, labels map[int][]byte, text map[int]string) {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	if len(keys) == 0 {
+		return // Only the symbol table is listed
+	}
+
+	fmt.Fprintln(w, "Abs\tInst")
+	fmt.Fprintln(w, "Addr\tData\tTag\t\tInstruction")
+	fmt.Fprintln(w, "-----\t----\t--------\t-----------")
+	lastAddr := keys[0]
+	lastField := 0
+	for _, addr := range keys {
+		if field := addr >> 12; field != lastField {
+			// Show where the field changes
+			fmt.Fprintf(w, "\t\t\t\tFIELD %o\n", field)
+			lastField = field
+		} else if addr-lastAddr > 1 {
+			fmt.Fprintf(w, "    :\n")
+		}
+		inst := m[addr]
+		var label []byte
+		var line []byte
+		if l, exists := labels[addr]; exists {
+			// Add trailing comma
+			label = append(bytes.TrimSpace(l), ',')
+
+			// Cut label from instruction if on the same line
+			line = bytes.TrimPrefix(lst[addr], label)
+
+			// Add extra tab for alignment (allows for labels > 8 char long)
+			if len(label) < 8 {
+				label = append(label, '\t')
+			}
+		} else {
+			line = lst[addr]
+			label = []byte("\t")
+		}
+		// Trim leading/trailing whitespace and remove newlines (in case of errors)
+		line = bytes.TrimSpace(bytes.ReplaceAll(line, []byte("\n"), []byte("")))
+
+		// Packed text shows its characters instead of the source comment. The
+		// delimiters can be slashes, so the line isn't split at them.
+		if chars, ok := text[addr]; ok {
+			_, cont := text[addr-1]
+			if cont && len(label) == 1 && bytes.Equal(lst[addr-1], lst[addr]) {
+				line = []byte("\t\t") // Only print the text on its first word
+			} else if len(line) < 8 {
+				line = append(line, '\t', '\t')
+			} else if len(line) < 16 {
+				line = append(line, '\t')
+			}
+			var comment string
+			if chars != "" {
+				comment = "/ " + chars
+			}
+			fmt.Fprintf(w, "%.4o,\t%.4o\t%s\t%s\t%s\n", addr&0o7777, inst, label, line, comment)
+			lastAddr = addr
+			continue
+		}
+
+		before, after, found := bytes.Cut(line, []byte("/"))
+		var comment []byte
+		if found {
+			line = bytes.TrimSpace(before)
+			if len(line) < 8 {
+				line = append(line, '\t', '\t')
+			} else if len(line) < 16 {
+				line = append(line, '\t')
+			}
+			comment = append([]byte("/ "), bytes.TrimSpace(after)...)
+		} else {
+			comment = []byte("")
+		}
+
+		// Check for string in line
+		if bytes.ContainsAny(line, "\"") {
+			// Only print the full string on the first line with the label
+			if len(label) == 1 && label[0] == '\t' {
+				line = []byte("\t\t")
+
+				// Add comment with ascii character of memory location
+				if len(comment) == 0 {
+					char := byte(m[addr])
+					if char == 0 {
+						comment = []byte("/ NULL")
+					} else if char == '\n' {
+						comment = []byte("/ \"\\n\"")
+					} else {
+						comment = []byte{'/', ' ', '"', byte(m[addr]), '"'}
+					}
+				}
+			} else {
+				// Add comment of character if line is short enough
+				if len(line) < 24 {
+					char := byte(m[addr])
+					if char == 0 {
+						comment = []byte("/ NULL")
+					} else if char == '\n' {
+						comment = []byte("/ \"\\n\"")
+					} else {
+						comment = []byte{'/', ' ', '"', byte(m[addr]), '"'}
+					}
+				}
+
+			}
+		}
+		fmt.Fprintf(w, "%.4o,\t%.4o\t%s\t%s\t%s\n", addr&0o7777, inst, label, line, comment)
+		lastAddr = addr
+	}
+	fmt.Fprintln(w, "$")
+}
+
+func (m Memory) ExportSize(w io.Writer) {
+	fields := m.Fields()
+	fmt.Fprintf(w, "Memory Usage Summary:\n")
+	for _, field := range fields {
+		if len(fields) > 1 || field != 0 {
+			fmt.Fprintf(w, "  Field %o:\n", field)
+		}
+		m.exportFieldSize(w, field)
+	}
+	if len(fields) > 1 {
+		wordsTotal := len(fields) * 0o10000
+		wordsUsed := len(m)
+		wordsPercent := (float32(wordsUsed) / float32(wordsTotal)) * 100
+		fmt.Fprintf(w, "  All Fields:\n")
+		fmt.Fprintf(w, "    Total Memory      used %5o₈ (%5d) of %6o₈ (%5d) words  (%5.1f%%)\n", wordsUsed, wordsUsed, wordsTotal, wordsTotal, wordsPercent)
+	}
+}
+
+func (m Memory) exportFieldSize(w io.Writer, field int) {
+	wordsTotal := 0o10000
+	wordsUsed := 0
+
+	zeroTotal := 0o200
+	zeroUsed := 0
+	autoTotal := 0o10
+	autoUsed := 0
+	for extAddr := range m {
+		if extAddr>>12 != field {
+			continue
+		}
+		addr := extAddr & 0o7777
+		wordsUsed++
+		if addr <= 0o17 && addr >= 0o10 {
+			autoUsed++
+		}
+		if addr < 0o200 {
+			zeroUsed++
+		}
+	}
+	wordsPercent := (float32(wordsUsed) / float32(wordsTotal)) * 100
+	zeroPercent := (float32(zeroUsed) / float32(zeroTotal)) * 100
+	autoPercent := (float32(autoUsed) / float32(autoTotal)) * 100
+	fmt.Fprintf(w, "    Auto Locations    used %5o₈ (%4d) of %5o₈ (%4d) words  (%5.1f%%)\n", autoUsed, autoUsed, autoTotal, autoTotal, autoPercent)
+	fmt.Fprintf(w, "    Zero Page         used %5o₈ (%4d) of %5o₈ (%4d) words  (%5.1f%%)\n", zeroUsed, zeroUsed, zeroTotal, zeroTotal, zeroPercent)
+	fmt.Fprintf(w, "    Total Memory      used %5o₈ (%4d) of %5o₈ (%4d) words  (%5.1f%%)\n", wordsUsed, wordsUsed, wordsTotal, wordsTotal, wordsPercent)
+}
+
+// Write the listing of an assembled program followed by its symbol table
+func (prog *Program) ExportListing(w io.Writer) {
+	prog.Mem.exportListing(w, prog.Listing, prog.Tags, prog.Text)
+	prog.exportSymbols(w)
+}
+
+// Write the symbol table of a listing. Every symbol defined in the source is
+// listed alphabetically with its value, type and the line it was defined on.
+func (prog *Program) exportSymbols(w io.Writer) {
+	fmt.Fprintln(w, "\nSymbol\t\tValue\tType\tLine")
+	fmt.Fprintln(w, "--------\t-----\t-----\t----")
+	for _, name := range prog.definedSymbols() {
+		sym := prog.Symbols.Get(name)
+		fmt.Fprintf(w, "%-8s\t%.4o\t%s\t%s\n", name, sym.Val, sym.Type, prog.lineRef(prog.Defs[name]))
+	}
+}
+
+// Write the cross-reference of a listing. Every symbol defined in the source is
+// listed alphabetically with the line it was defined on and each line that
+// uses it.
+func (prog *Program) ExportCrossReference(w io.Writer) {
+	fmt.Fprintln(w, "\nSymbol\t\tDefined\tReferences")
+	fmt.Fprintln(w, "--------\t-------\t----------")
+	for _, name := range prog.definedSymbols() {
+		var refs []string
+		seen := make(map[string]bool)
+		for _, lm := range prog.Refs[name] {
+			if ref := prog.lineRef(lm); !seen[ref] {
+				refs = append(refs, ref)
+				seen[ref] = true
+			}
+		}
+		fmt.Fprintf(w, "%-8s\t%s\t%s\n", name, prog.lineRef(prog.Defs[name]), strings.Join(refs, " "))
+	}
+}
+
+// Get the sorted names of the symbols defined in the source. Labels local to a
+// macro expansion are left out.
+func (prog *Program) definedSymbols() []string {
+	names := make([]string, 0, len(prog.Defs))
+	for name := range prog.Defs {
+		if !strings.Contains(name, ".") && prog.Symbols.Get(name) != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get the line of a lexeme for a listing, lines in other files than the first
+// source file are prefixed with the file name
+func (prog *Program) lineRef(lm Lexeme) string {
+	if lm.File != prog.name {
+		return filepath.Base(lm.File) + ":" + strconv.Itoa(lm.Line)
+	}
+	return strconv.Itoa(lm.Line)
+}
@@ -0,0 +1,37 @@
+package pal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExportListingWithoutWords(t *testing.T) {
+	prog, diags := assembleString("A=5\nB=A+1\n$\n")
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	var b strings.Builder
+	prog.ExportListing(&b)
+	if !strings.Contains(b.String(), "B       \t0006") {
+		t.Errorf("symbol table missing from listing:\n%s", b.String())
+	}
+	if strings.Contains(b.String(), "Abs\tInst") {
+		t.Errorf("empty memory listed:\n%s", b.String())
+	}
+}
+
+func TestExportListingLinks(t *testing.T) {
+	src := "*200\nSTART,\tJMP FAR\t/ go far\n\tTAD FAR\n*1000\nFAR,\tHLT\n$\n"
+	prog, _ := Assemble(strings.NewReader(src), Options{Name: "test.pa", Links: true})
+	var b strings.Builder
+	prog.ExportListing(&b)
+	for _, want := range []string{
+		"0200,\t5777\tSTART,\t\tJMP FAR\t\t\t/ Indirect via link at 0377, go far\n",
+		"0201,\t1777\t\t\tTAD FAR\t\t\t/ Indirect via link at 0377\n",
+		"0377,\t1000\t\t\tFAR\t\t\t/ Link\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("listing doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package pal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Read a P Object file back into memory. See ExportPObject for the format.
+func ImportPObject(r io.Reader) (Memory, error) {
+	m := make(Memory)
+	s := bufio.NewScanner(r)
+	addr := 0
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		val, err := strconv.ParseInt(line, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid octal number: %s", lineNum, line)
+		}
+		if val&0o170000 == 0o170000 {
+			// Change of address
+			addr = int(val) & 0o7777
+		} else {
+			m[addr] = int(val) & 0o7777
+			addr = (addr + 1) & 0o7777
+		}
+	}
+	return m, s.Err()
+}
+
+// Read a relocatable object file. See ExportRel for the format.
+func ImportRel(r io.Reader) (*Object, error) {
+	obj := &Object{
+		Mem:       make(Memory),
+		Relocs:    make(map[int]bool),
+		Externals: make(map[int]string),
+		Entries:   make(map[string]Entry),
+	}
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if obj.Name == "" {
+			if len(fields) != 2 || fields[0] != "MODULE" {
+				return nil, fmt.Errorf("line %d: expected MODULE name", lineNum)
+			}
+			obj.Name = fields[1]
+			continue
+		}
+
+		// Both entries and words have an octal value followed by an optional
+		// relocation
+		name := ""
+		if fields[0] == "ENTRY" {
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: expected ENTRY name value", lineNum)
+			}
+			name = fields[1]
+			fields = fields[1:]
+		} else if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected address and value", lineNum)
+		}
+		val, err := strconv.ParseInt(fields[1], 8, 16)
+		if err != nil || val > 0o7777 {
+			return nil, fmt.Errorf("line %d: invalid octal value: %s", lineNum, fields[1])
+		}
+		reloc := fields[2:]
+		if name != "" {
+			if len(reloc) > 1 || (len(reloc) == 1 && reloc[0] != "R") {
+				return nil, fmt.Errorf("line %d: invalid entry", lineNum)
+			}
+			obj.Entries[name] = Entry{int(val), len(reloc) == 1}
+			continue
+		}
+
+		addr, err := strconv.ParseInt(fields[0], 8, 16)
+		if err != nil || addr > 0o7777 {
+			return nil, fmt.Errorf("line %d: invalid octal address: %s", lineNum, fields[0])
+		}
+		switch {
+		case len(reloc) == 0:
+		case len(reloc) == 1 && reloc[0] == "R":
+			obj.Relocs[int(addr)] = true
+		case len(reloc) == 2 && reloc[0] == "X":
+			obj.Externals[int(addr)] = reloc[1]
+		default:
+			return nil, fmt.Errorf("line %d: invalid relocation: %s", lineNum, strings.Join(reloc, " "))
+		}
+		obj.Mem[int(addr)] = int(val)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if obj.Name == "" {
+		return nil, errors.New("missing MODULE name")
+	}
+	return obj, nil
+}
+
+// Read a library file. See ExportLib for the format. Every module has to be
+// in the index with each of its entries.
+func ImportLib(r io.Reader) (*Library, error) {
+	lib := &Library{Index: make(map[string]string)}
+	s := bufio.NewScanner(r)
+	var module []string // Lines of the module being read
+	addModule := func() error {
+		if module == nil {
+			return nil
+		}
+		obj, err := ImportRel(strings.NewReader(strings.Join(module, "\n")))
+		if err != nil {
+			return fmt.Errorf("module %s: %v", strings.TrimPrefix(module[0], "MODULE "), err)
+		}
+		lib.Modules = append(lib.Modules, obj)
+		return nil
+	}
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		fields := strings.Fields(line)
+		switch {
+		case lineNum == 1:
+			if line != "LIBRARY" {
+				return nil, errors.New("line 1: expected LIBRARY")
+			}
+		case len(fields) == 3 && fields[0] == "INDEX" && module == nil:
+			lib.Index[fields[1]] = fields[2]
+		case len(fields) > 0 && fields[0] == "MODULE":
+			if err := addModule(); err != nil {
+				return nil, err
+			}
+			module = []string{line}
+		case module != nil:
+			module = append(module, line)
+		case len(fields) > 0:
+			return nil, fmt.Errorf("line %d: expected INDEX or MODULE", lineNum)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if err := addModule(); err != nil {
+		return nil, err
+	}
+
+	// The index has to match the modules
+	check, err := NewLibrary(lib.Modules)
+	if err != nil {
+		return nil, err
+	}
+	if len(check.Index) != len(lib.Index) {
+		return nil, errors.New("index doesn't match the modules")
+	}
+	for name, owner := range check.Index {
+		if lib.Index[name] != owner {
+			return nil, fmt.Errorf("index doesn't match the modules: %s", name)
+		}
+	}
+	return lib, nil
+}
+
+// Read a RIM tape back into memory. See ExportRim for the format.
+func ImportRim(r io.Reader) (Memory, error) {
+	tape, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(Memory)
+	for i := 0; i < len(tape); {
+		if tape[i]&0o200 != 0 { // Leader/trailer
+			i++
+			continue
+		}
+		if i+3 >= len(tape) {
+			return nil, errors.New("unexpected end of tape")
+		}
+		if tape[i]&0o100 == 0 {
+			return nil, fmt.Errorf("expected address at offset %d", i)
+		}
+		addr := int(tape[i]&0o77)<<6 | int(tape[i+1]&0o77)
+		m[addr] = int(tape[i+2]&0o77)<<6 | int(tape[i+3]&0o77)
+		i += 4
+	}
+	return m, nil
+}
+
+// Read a BIN tape back into memory. See ExportBin for the format. The
+// checksum at the end of the tape is verified.
+func ImportBin(r io.Reader) (Memory, error) {
+	tape, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(Memory)
+	var field, addr, checksum int
+	// The last word on the tape is the checksum, so each word is only stored
+	// once another one is read.
+	var pending bool
+	var pendingAddr, pendingWord, pendingSum int
+	for i := 0; i < len(tape); i++ {
+		b := tape[i]
+		switch {
+		case b == 0o200 || b == 0o377: // Leader/trailer or rubout
+			continue
+
+		case b&0o300 == 0o300: // Field setting
+			field = int(b>>3) & 0o7
+
+		case b&0o300 == 0o100, b&0o300 == 0: // Origin or data
+			if i+1 >= len(tape) {
+				return nil, errors.New("unexpected end of tape")
+			}
+			val := int(b&0o77)<<6 | int(tape[i+1]&0o77)
+			sum := int(b) + int(tape[i+1])
+			i++
+			if b&0o100 != 0 {
+				addr = val
+				checksum += sum
+				continue
+			}
+			if pending {
+				m[pendingAddr] = pendingWord
+				checksum += pendingSum
+			}
+			pending = true
+			pendingAddr, pendingWord, pendingSum = field<<12|addr, val, sum
+			addr = (addr + 1) & 0o7777
+
+		default:
+			return nil, fmt.Errorf("unknown frame %o at offset %d", b, i)
+		}
+	}
+	if !pending {
+		return nil, errors.New("no checksum on tape")
+	}
+	if checksum&0o7777 != pendingWord {
+		return nil, fmt.Errorf("checksum mismatch: tape has %.4o, computed %.4o", pendingWord, checksum&0o7777)
+	}
+	return m, nil
+}
+
+// Read an Intel HEX file back into memory. See ExportIntelHex for the format.
+func ImportIntelHex(r io.Reader) (Memory, error) {
+	m := make(Memory)
+	s := bufio.NewScanner(r)
+	upper := 0
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' || len(line) < 11 || len(line)%2 == 0 {
+			return nil, fmt.Errorf("line %d: invalid record", lineNum)
+		}
+		record := make([]byte, (len(line)-1)/2)
+		var sum byte
+		for i := range record {
+			b, err := strconv.ParseUint(line[1+i*2:3+i*2], 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid hex digit", lineNum)
+			}
+			record[i] = byte(b)
+			sum += byte(b)
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d: checksum mismatch", lineNum)
+		}
+		data := record[4 : len(record)-1]
+		if int(record[0]) != len(data) {
+			return nil, fmt.Errorf("line %d: record length mismatch", lineNum)
+		}
+		switch record[3] {
+		case 0x00: // Data
+			byteAddr := upper<<16 | int(record[1])<<8 | int(record[2])
+			for i := 0; i+1 < len(data); i += 2 {
+				m[(byteAddr+i)/2] = (int(data[i]) | int(data[i+1])<<8) & 0o7777
+			}
+		case 0x01: // End of file
+			return m, nil
+		case 0x04: // Extended linear address
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d: invalid extended address", lineNum)
+			}
+			upper = int(data[0])<<8 | int(data[1])
+		}
+	}
+	return m, s.Err()
+}
@@ -0,0 +1,643 @@
+package pal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+)
+
+// Maximum depth of nested INCLUDE files. This stops files that include
+// themselves from running forever.
+const maxIncludeDepth = 16
+
+type LexType int
+
+const (
+	SYMBOL LexType = iota
+	PUNCTUATION
+	NUMBER
+	COMMENT
+	STRING
+	CHAR
+	TEXT
+	FLOAT
+	EOL
+	EOF
+	UNKNOWN
+)
+
+type Lexeme struct {
+	// The type of the lexeme
+	Type LexType
+
+	// The raw lexeme bytes
+	Bytes []byte
+
+	// Location of lexeme in file
+	File string
+	Line int
+	Col  int
+
+	// The source line the lexeme was read from
+	Src []byte
+}
+
+type Lexer struct {
+	// Th previous lexeme that was parsed
+	Prev Lexeme
+	// The current lexeme being parsed
+	This Lexeme
+	// The next lexeme to be parsed
+	Next Lexeme
+
+	// Name of the file being scanned
+	name string
+	// Assembler options
+	opts *Options
+	// Lexer line scanner
+	s *bufio.Scanner
+
+	// Number of the line of file
+	lineNum int
+	// Buffer which holds the raw line bytes
+	line []byte
+	// Scan position in line
+	pos int
+
+	// Source files to assemble, in order
+	files []string
+	// Index of the next source file to scan
+	nextFile int
+	// Files that are waiting for an included file to finish
+	stack []sourceFile
+	// Contents of every file that has been read, by name. Each file is only
+	// read once and scanned again from memory in the second pass.
+	sources map[string][]byte
+
+	// Called with problems found while scanning
+	report func(Diagnostic)
+
+	// Lexemes to return before scanning resumes
+	queue []Lexeme
+	// Lexemes recorded since Record was called
+	rec       []Lexeme
+	recording bool
+
+	// The next lexeme is the delimited operand of a text pseudo-op
+	delimited bool
+	// The numbers up to the end of the statement are floating point numbers
+	floating bool
+}
+
+// Scanning state of a file that is waiting for an included file to finish
+type sourceFile struct {
+	name    string
+	s       *bufio.Scanner
+	lineNum int
+	line    []byte
+	pos     int
+}
+
+// Create a lexer that scans each of the source files in turn, as if they were
+// one file. Sources that are already read can be given by name, any other file
+// is read when it is first scanned.
+func NewLexer(files []string, sources map[string][]byte, opts *Options) (l *Lexer) {
+	l = new(Lexer)
+	l.opts = opts
+	l.files = files
+	l.sources = sources
+
+	// Open the first file and read the first lexeme into Next. A successive
+	// call to Advance will place this lexeme into This, and scan a new one into
+	// Next.
+	l.Reset()
+
+	return
+}
+
+// Create a lexer that returns the given lexemes followed by EOF instead of
+// scanning a file. This is used to evaluate previously recorded expressions.
+func NewReplayLexer(lms []Lexeme, opts *Options) (l *Lexer) {
+	l = new(Lexer)
+	l.opts = opts
+	l.queue = append(l.queue, lms...)
+	l.queue = append(l.queue, Lexeme{Type: EOF, Bytes: []byte{0}})
+
+	// Read the first lexeme into Next
+	l.Advance()
+
+	return
+}
+
+// Insert lexemes to be returned by Advance before the next lexeme. This is how
+// macro expansions are fed back into the parser.
+func (l *Lexer) Push(lms []Lexeme) {
+	if len(lms) == 0 {
+		return
+	}
+	queue := make([]Lexeme, 0, len(lms)+len(l.queue))
+	queue = append(queue, lms[1:]...)
+	queue = append(queue, l.Next)
+	queue = append(queue, l.queue...)
+	l.queue = queue
+	l.Next = lms[0]
+}
+
+// Start recording lexemes, beginning with the current lexeme
+func (l *Lexer) Record() {
+	l.rec = []Lexeme{l.This}
+	l.recording = true
+}
+
+// Stop recording lexemes and return everything from the call to Record up to
+// and including the current lexeme
+func (l *Lexer) StopRecording() []Lexeme {
+	l.recording = false
+	return l.rec
+}
+
+// Start scanning again from the beginning of the first source file
+func (l *Lexer) Reset() {
+	l.queue = nil
+	l.stack = nil
+	l.delimited = false
+	l.floating = false
+
+	if err := l.open(l.files[0]); err != nil {
+		panic(err)
+	}
+	l.nextFile = 1
+	l.Advance()
+}
+
+// Get the contents of a file, reading it if it hasn't been read before
+func (l *Lexer) read(name string) ([]byte, error) {
+	if src, ok := l.sources[name]; ok {
+		return src, nil
+	}
+	src, err := readSource(name, l.opts)
+	if err != nil {
+		return nil, err
+	}
+	l.sources[name] = src
+	return src, nil
+}
+
+// Open a file and start scanning it from the first line
+func (l *Lexer) open(name string) error {
+	src, err := l.read(name)
+	if err != nil {
+		return err
+	}
+	l.name = name
+	l.lineNum = 0
+
+	// Create a new scanner on our reader and set our custom splitLine function
+	l.s = bufio.NewScanner(bytes.NewReader(src))
+	l.s.Split(scanLines)
+
+	// Read the first line into line buffer
+	l.readLine()
+	return nil
+}
+
+// Scan a file before the rest of the current file. Scanning continues after
+// the current line once the included file ends.
+func (l *Lexer) Include(name string) error {
+	if len(l.stack) >= maxIncludeDepth {
+		return errors.New("includes are nested too deeply")
+	}
+	src := sourceFile{l.name, l.s, l.lineNum, l.line, l.pos}
+	if err := l.open(name); err != nil {
+		return err
+	}
+	l.stack = append(l.stack, src)
+	return nil
+}
+
+// Move on to the file that follows the current one, either the file that
+// included it or the next source file. Returns false if there are no files
+// left.
+func (l *Lexer) nextSource() bool {
+	if len(l.stack) > 0 {
+		src := l.stack[len(l.stack)-1]
+		l.stack = l.stack[:len(l.stack)-1]
+		l.name, l.s, l.lineNum, l.line, l.pos = src.name, src.s, src.lineNum, src.line, src.pos
+		return true
+	}
+	if l.nextFile < len(l.files) {
+		if err := l.open(l.files[l.nextFile]); err != nil {
+			panic(err)
+		}
+		l.nextFile++
+		return true
+	}
+	return false
+}
+
+// Advance the current lexeme by one position, moving next -> this and reading
+// a new lexeme into next
+func (l *Lexer) Advance() {
+	l.Prev = l.This
+	l.This = l.Next
+	if l.recording {
+		l.rec = append(l.rec, l.This)
+	}
+
+	// Return queued lexemes first
+	if len(l.queue) > 0 {
+		l.Next = l.queue[0]
+		l.queue = l.queue[1:]
+		return
+	} else if l.s == nil {
+		// Replay lexers have nothing to scan
+		l.Next = Lexeme{Type: EOF, Bytes: []byte{0}, Line: l.This.Line, Col: l.This.Col}
+		return
+	}
+	l.scan()
+}
+
+// Scan the next lexeme of the file into Next
+func (l *Lexer) scan() {
+	l.Next.Type = UNKNOWN
+	l.Next.Bytes = nil
+	l.Next.File = l.name
+	l.Next.Line = l.lineNum
+
+	// fmt.Println("Scanning line:", l.line)
+	// fmt.Printf("%d, %d\t[%d]\t%s\n", l.This.Line, l.This.Col, l.This.Type, strings.TrimSpace(string(l.This.Bytes)))
+
+	// Only the lexeme right after a text pseudo-op can be delimited text
+	delimited := l.delimited
+	l.delimited = false
+
+	// Skip Whitespace
+	l.skipWhitespace()
+
+	// Set column of next lexeme
+	l.Next.Col = l.pos + 1
+	l.Next.Src = l.line
+
+	// Check if we're at the end of the file. The end of every file but the
+	// last one is an EOL so statements can't run into the next file.
+	if l.pos == -1 || l.line[l.pos] == 0 || l.line[l.pos] == '$' {
+		l.floating = false
+		if l.nextSource() {
+			l.Next.Type = EOL
+			l.Next.Bytes = []byte{'\n'}
+			return
+		}
+		l.Next.Type = EOF
+		l.Next.Bytes = []byte{0}
+		return
+	}
+
+	// Check if we're at EOL
+	if l.line[l.pos] == '\n' || l.line[l.pos] == ';' {
+		l.floating = false
+		l.Next.Type = EOL
+		l.Next.Bytes = []byte{'\n'} // Should we emit the actual line ending? (either ';' or '\n')
+		// If its a colon delimited line, go ahead and increment pos. This will
+		// catch colons at the end of line and any empty lines afterwards.
+		if l.line[l.pos] == ';' {
+			l.pos++
+		}
+		// Skip blank lines or lines that only contain whitespace
+		for l.line[l.pos] == '\n' {
+			l.readLine()
+			if l.pos == -1 {
+				break
+			}
+			l.skipWhitespace()
+		}
+		return // Bail
+	}
+
+	// The operand of a text pseudo-op is delimited by its first character,
+	// which can be one that would otherwise start a comment
+	if delimited {
+		l.scanText()
+		return // Bail
+	}
+
+	// Operands of FLTG are scanned as whole floating point numbers, which
+	// would otherwise be split at the point and exponent
+	if l.floating && isFloatStart(l.line[l.pos]) {
+		l.scanFloat()
+		return // Bail
+	}
+
+	// Check for comment and read the rest of the line as a comment lexeme
+	if l.line[l.pos] == '/' {
+		l.Next.Type = COMMENT
+		l.Next.Bytes = bytes.Clone(l.line[l.pos : len(l.line)-1])
+		l.pos = len(l.line) - 1
+		return // Bail early
+	}
+
+	// Check for double quoted strings. These are only statements in PAL-D
+	// syntax, but are also used for file names.
+	if l.line[l.pos] == '"' {
+		l.Next.Type = STRING
+		start := l.pos
+		l.pos++
+		for c := l.line[l.pos]; c != '"'; {
+			l.pos++
+			if l.pos >= len(l.line) {
+				break
+			} else {
+				c = l.line[l.pos]
+			}
+		}
+
+		if l.pos < len(l.line) && l.line[l.pos] == '"' { // Include trailing "
+			l.pos++
+			l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+		} else {
+			// Leave the line ending to be scanned next
+			l.pos = len(l.line) - 1
+			l.Next.Type = UNKNOWN
+			l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+			l.UnknownLexeme(&l.Next, -1, "unterminated string")
+		}
+		return // Bail
+	}
+
+	if l.opts.PalD { // PAL-D doesn't actually support this
+
+		// Check for single quoted characters
+		if l.line[l.pos] == '\'' {
+			l.Next.Type = CHAR
+			start := l.pos
+			l.pos++                    // Skip leading '
+			if l.line[l.pos] == '\\' { // Catch characters escaped with \
+				l.pos++
+				// valid := isEscaped(l.line[l.pos])
+				// if !valid {
+				// 	l.UnknownLexeme(&l.Next, l.pos+1, "unknown escaped character")
+				// 	// panic("unknown char")
+				// }
+			}
+
+			l.pos++ // Skip the character
+
+			// fmt.Println(string(l.line))
+			// fmt.Println(string(l.line[l.pos]))
+
+			// Check for ending ' (not required)
+			if l.line[l.pos] == '\'' {
+				l.Next.Bytes = bytes.Clone(l.line[start : l.pos+1])
+				l.pos++
+			} else if isWhitespace(l.line[l.pos]) || l.line[l.pos] == '\n' || l.line[l.pos] == ';' {
+				l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+			} else {
+				// Skip the rest of the lexeme
+				for !isWhitespace(l.line[l.pos]) && l.line[l.pos] != '\n' && l.line[l.pos] != ';' && l.line[l.pos] != 0 {
+					l.pos++
+				}
+				l.Next.Type = UNKNOWN
+				l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+				l.UnknownLexeme(&l.Next, -1, "unknown character")
+			}
+			return // Bail
+
+		}
+	}
+
+	// Check for valid punctuation lexemes
+	if p := l.line[l.pos]; p == '=' || p == '*' || p == ',' || p == '.' || p == '(' || p == ')' || p == '[' || p == ']' || p == '<' || p == '>' || isOperator(p) {
+		l.Next.Type = PUNCTUATION
+		l.Next.Bytes = bytes.Clone(l.line[l.pos : l.pos+1])
+		l.pos++
+		return // Bail early
+	}
+
+	// Check for symbols, numbers, and unknown lexemes
+	if isLetter(l.line[l.pos]) {
+		// fmt.Println("Found symbol:", string(l.line[l.pos:]))
+		// Symbols are alphanumeric and start with a letter
+		start := l.pos
+		l.pos++
+		for c := l.line[l.pos]; isAlphaNum(c); {
+			l.pos++
+			if l.pos == len(l.line) {
+				break
+			} else {
+				c = l.line[l.pos]
+			}
+		}
+		l.Next.Type = SYMBOL
+		l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+
+		// Text pseudo-ops take delimited text and FLTG takes floating point
+		// numbers, unless the symbol is a label or is being defined
+		if isTextOp(l.Next.Bytes) || string(l.Next.Bytes) == "FLTG" {
+			next := l.pos
+			for next < len(l.line) && isWhitespace(l.line[next]) {
+				next++
+			}
+			operand := next < len(l.line) && l.line[next] != ',' && l.line[next] != '='
+			l.delimited = operand && isTextOp(l.Next.Bytes)
+			l.floating = operand && !l.delimited
+		}
+
+	} else if isDigit(l.line[l.pos]) {
+		// fmt.Println("Found number:", string(l.line[l.pos:]))
+		//Numbers contain digits
+		start := l.pos
+		l.pos++
+
+		var matchFunc func(c byte) bool = isDigit
+		if c := l.line[l.pos]; c == 'b' || c == 'o' || c == 'd' {
+			// Number base that only includes digits 0-9
+			l.pos++
+		} else if c == 'x' {
+			// Hex numbers can contain some letters as digits
+			matchFunc = isHexDigit
+			l.pos++
+		}
+		for c := l.line[l.pos]; matchFunc(c); {
+			l.pos++
+			if l.pos == len(l.line) {
+				break
+			}
+			c = l.line[l.pos]
+		}
+		l.Next.Type = NUMBER
+		l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+
+	} else {
+		// Invalid character, report it and scan the lexeme after it
+		l.Next.Bytes = l.line[l.pos : l.pos+1]
+		l.UnknownLexeme(&l.Next, -1, "unknown character")
+		l.pos++
+		l.scan()
+	}
+}
+
+// Scan text enclosed in a pair of delimiters into Next. The delimiter is the
+// character at the scan position and the text has to end on the same line.
+func (l *Lexer) scanText() {
+	l.Next.Type = TEXT
+	start := l.pos
+	end := bytes.IndexByte(l.line[start+1:], l.line[start])
+	if end < 0 {
+		// Leave the line ending to be scanned next
+		l.pos = len(l.line) - 1
+		l.Next.Type = UNKNOWN
+		l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+		l.UnknownLexeme(&l.Next, -1, "unterminated text")
+		return
+	}
+	l.pos = start + end + 2 // Include the closing delimiter
+	l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+}
+
+// Scan a decimal floating point number into Next: an optional sign, digits
+// with an optional point and an optional exponent (1.5, -.25, 3E-2)
+func (l *Lexer) scanFloat() {
+	l.Next.Type = FLOAT
+	start := l.pos
+	if c := l.line[l.pos]; c == '+' || c == '-' {
+		l.pos++
+	}
+	for isDigit(l.line[l.pos]) || l.line[l.pos] == '.' {
+		l.pos++
+	}
+	if c := l.line[l.pos]; c == 'E' || c == 'e' {
+		l.pos++
+		if c := l.line[l.pos]; c == '+' || c == '-' {
+			l.pos++
+		}
+		for isDigit(l.line[l.pos]) {
+			l.pos++
+		}
+	}
+	l.Next.Bytes = bytes.Clone(l.line[start:l.pos])
+}
+
+func isFloatStart(c byte) bool {
+	return isDigit(c) || c == '.' || c == '+' || c == '-'
+}
+
+// Check if a symbol is a pseudo-op that takes delimited text
+func isTextOp(sym []byte) bool {
+	return string(sym) == "TEXT" || string(sym) == "ASCII"
+}
+
+// Custom scanLine function. Lines keep their trailing \n, and a NULL byte is
+// appended as the EOF character
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		// We have a full newline-terminated line.
+		return i + 1, data[0 : i+1], nil
+	}
+	// If we're at EOF, we have a final, non-terminated line. Return it with a terminating NULL
+	if atEOF {
+		return len(data), append(data, 0), nil
+	}
+	// Request more data.
+	return 0, nil, nil
+}
+
+// Reads the current line into buffer
+func (l *Lexer) readLine() {
+	if l.s.Scan() {
+		// Lexemes keep a reference to their line so it can't be reused
+		l.line = bytes.Clone(l.s.Bytes())
+		l.pos = 0
+	} else {
+		l.line = nil
+		l.pos = -1
+	}
+	l.lineNum++
+}
+
+func (l *Lexer) skipWhitespace() {
+	leng := len(l.line)
+	if l.pos == leng || l.pos == -1 {
+		return // Bail early if pointer is at end of line or file
+	}
+	for c := l.line[l.pos]; isWhitespace(c); {
+		l.pos++
+		if l.pos == leng {
+			break
+		} else {
+			c = l.line[l.pos]
+		}
+	}
+}
+
+func isWhitespace(c byte) bool {
+	if c == ' ' || c == '\t' {
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	if c >= '0' && c <= '9' {
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	if isDigit(c) ||
+		(c == 'a' || c == 'A') ||
+		(c == 'b' || c == 'B') ||
+		(c == 'c' || c == 'C') ||
+		(c == 'd' || c == 'D') ||
+		(c == 'e' || c == 'E') ||
+		(c == 'f' || c == 'F') {
+		return true
+	}
+	return false
+}
+
+func isLetter(c byte) bool {
+	if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') {
+		return true
+	}
+	return false
+}
+
+// Operators that can be used between two operands of an expression
+func isOperator(c byte) bool {
+	if c == '+' || c == '-' || c == '&' || c == '!' || c == '^' || c == '%' {
+		return true
+	}
+	return false
+}
+
+func isAlphaNum(c byte) bool {
+	if isDigit(c) || isLetter(c) {
+		return true
+	}
+	return false
+}
+
+// func isEscaped(c byte) bool {
+// 	if c == 'n' || c == 'r' || c == 't' || c == '\\' {
+// 		return true
+// 	} else {
+// 		return false
+// 	}
+// }
+
+// Check if a name can be used as a symbol, symbols start with a letter and
+// only contain letters and digits
+func IsSymbolName(name string) bool {
+	if name == "" || !isLetter(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isAlphaNum(name[i]) {
+			return false
+		}
+	}
+	return true
+}
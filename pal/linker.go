@@ -0,0 +1,239 @@
+package pal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A relocatable module for the linker. Addresses from 0200 up are relative to
+// the first page of the module, the linker moves them by whole pages so that
+// current page references stay on the same page. Addresses on the zero page
+// are absolute and shared by every module.
+type Object struct {
+	Name string
+	Mem  Memory
+
+	// Words that hold a relocatable address. The distance the module was
+	// moved is added to them.
+	Relocs map[int]bool
+	// Words that hold the address of an external symbol, by address. The
+	// address of the symbol is added to them.
+	Externals map[int]string
+	// Symbols that other modules can use, by name
+	Entries map[string]Entry
+}
+
+// A symbol that a module makes available to other modules
+type Entry struct {
+	Value       int
+	Relocatable bool
+}
+
+// An archive of relocatable modules with an index of their entries. Only the
+// modules that define a symbol another module needs are linked.
+type Library struct {
+	Modules []*Object
+	// Name of the module that defines each entry
+	Index map[string]string
+}
+
+// Create a library of modules. Module names and entries have to be unique
+// within a library.
+func NewLibrary(objs []*Object) (*Library, error) {
+	lib := &Library{Modules: objs, Index: make(map[string]string)}
+	names := make(map[string]bool)
+	for _, obj := range objs {
+		if names[obj.Name] {
+			return nil, fmt.Errorf("module %s is in the library twice", obj.Name)
+		}
+		names[obj.Name] = true
+		for entry := range obj.Entries {
+			if owner, exists := lib.Index[entry]; exists {
+				return nil, fmt.Errorf("%s is an entry of both %s and %s", entry, owner, obj.Name)
+			}
+			lib.Index[entry] = obj.Name
+		}
+	}
+	return lib, nil
+}
+
+// Get the module that defines an entry, or nil if no module does
+func (lib *Library) module(entry string) *Object {
+	name, ok := lib.Index[entry]
+	if !ok {
+		return nil
+	}
+	for _, obj := range lib.Modules {
+		if obj.Name == name {
+			return obj
+		}
+	}
+	return nil
+}
+
+// Get the module that was assembled, after the second pass
+func (p *Parser) object(name string) *Object {
+	obj := &Object{
+		Name:      name,
+		Mem:       p.mem,
+		Relocs:    make(map[int]bool),
+		Externals: make(map[int]string),
+		Entries:   make(map[string]Entry),
+	}
+	for addr, r := range p.relocs {
+		if r.extern != "" {
+			obj.Externals[addr] = r.extern
+		} else {
+			obj.Relocs[addr] = true
+		}
+	}
+	for name := range p.entries {
+		if sym := p.symtab.Get(name); sym != nil {
+			obj.Entries[name] = Entry{sym.Val, p.relSyms[name] == 1}
+		}
+	}
+	return obj
+}
+
+// Get the number of words from 0200 to the last word of the module
+func (obj *Object) size() int {
+	end := 0o200
+	for addr := range obj.Mem {
+		if addr >= end {
+			end = addr + 1
+		}
+	}
+	return end - 0o200
+}
+
+// Link modules into a program in memory field 0. The modules are placed in
+// order, each one starting on the page after the end of the one before it.
+// External symbols are resolved with the entries of every module. Modules
+// from the libraries are added after the others when they define a symbol
+// that is still undefined, the first library that defines it is used.
+func Link(objs []*Object, libs ...*Library) (Memory, error) {
+	objs = append(objs[:len(objs):len(objs)], pullModules(objs, libs)...)
+
+	// Place the modules
+	bases := make([]int, len(objs))
+	next := 0o200
+	for i, obj := range objs {
+		bases[i] = next
+		next += (obj.size() + 0o177) &^ 0o177
+		if next > 0o10000 {
+			return nil, fmt.Errorf("module %s doesn't fit in field 0", obj.Name)
+		}
+	}
+
+	// Collect the addresses of the entries
+	symbols := make(map[string]int)
+	owners := make(map[string]string)
+	for i, obj := range objs {
+		names := make([]string, 0, len(obj.Entries))
+		for name := range obj.Entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if owner, exists := owners[name]; exists {
+				return nil, fmt.Errorf("%s is an entry of both %s and %s", name, owner, obj.Name)
+			}
+			entry := obj.Entries[name]
+			symbols[name] = entry.Value
+			if entry.Relocatable {
+				symbols[name] += bases[i] - 0o200
+			}
+			owners[name] = obj.Name
+		}
+	}
+	if undefined := undefinedExternals(objs, symbols); len(undefined) > 0 {
+		return nil, fmt.Errorf("undefined external symbols: %s", strings.Join(undefined, ", "))
+	}
+
+	// Relocate every word. Modules can share zero page locations as long as
+	// they store the same value there.
+	mem := make(Memory)
+	users := make(map[int]string)
+	for i, obj := range objs {
+		delta := bases[i] - 0o200
+		for addr, val := range obj.Mem {
+			if obj.Relocs[addr] {
+				val += delta
+			}
+			if name, ok := obj.Externals[addr]; ok {
+				val += symbols[name]
+			}
+			val &= 0o7777
+			if addr >= 0o200 {
+				addr += delta
+			}
+			if user, used := users[addr]; used && mem[addr] != val {
+				return nil, fmt.Errorf("location %.4o is used by both %s and %s", addr, user, obj.Name)
+			}
+			mem[addr] = val
+			users[addr] = obj.Name
+		}
+	}
+	return mem, nil
+}
+
+// Get the library modules needed to resolve the external symbols of the
+// modules. The modules that are pulled in can need more modules in turn.
+func pullModules(objs []*Object, libs []*Library) []*Object {
+	linked := make(map[*Object]bool)
+	defined := make(map[string]bool)
+	add := func(obj *Object) {
+		linked[obj] = true
+		for name := range obj.Entries {
+			defined[name] = true
+		}
+	}
+	for _, obj := range objs {
+		add(obj)
+	}
+
+	all := append([]*Object{}, objs...)
+	for i := 0; i < len(all); i++ {
+		obj := all[i]
+
+		// Follow the references in address order so the modules are always
+		// pulled in the same order
+		addrs := make([]int, 0, len(obj.Externals))
+		for addr := range obj.Externals {
+			addrs = append(addrs, addr)
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			name := obj.Externals[addr]
+			if defined[name] {
+				continue
+			}
+			for _, lib := range libs {
+				if mod := lib.module(name); mod != nil && !linked[mod] {
+					add(mod)
+					all = append(all, mod)
+					break
+				}
+			}
+		}
+	}
+	return all[len(objs):]
+}
+
+// Get the sorted names of the external symbols of the modules that none of
+// them define
+func undefinedExternals(objs []*Object, symbols map[string]int) []string {
+	seen := make(map[string]bool)
+	var undefined []string
+	for _, obj := range objs {
+		for _, name := range obj.Externals {
+			if _, ok := symbols[name]; !ok && !seen[name] {
+				undefined = append(undefined, name)
+				seen[name] = true
+			}
+		}
+	}
+	sort.Strings(undefined)
+	return undefined
+}
@@ -0,0 +1,218 @@
+package pal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Calls PUT through a literal and has a pointer to BUF that is relocated
+const mainModule = `	EXTERNAL PUT
+	ENTRY START
+START,	TAD PTR
+	JMS I (PUT)
+	JMP START
+PTR,	BUF
+BUF,	0
+$
+`
+
+const ttyModule = `	ENTRY PUT
+PUT,	0
+	TLS
+	JMP I PUT
+$
+`
+
+// Assemble a relocatable module
+func assembleModule(t *testing.T, name, src string) *Object {
+	t.Helper()
+	prog, diags := Assemble(strings.NewReader(src), Options{Name: name + ".pa", Relocatable: true})
+	if len(diags) != 0 {
+		t.Fatalf("%s: unexpected diagnostics: %v", name, diags)
+	}
+	return prog.Object
+}
+
+func TestLink(t *testing.T) {
+	main := assembleModule(t, "main", mainModule)
+	tty := assembleModule(t, "tty", ttyModule)
+
+	tests := []struct {
+		name string
+		objs []*Object
+		mem  Memory
+	}{
+		{
+			name: "main first",
+			objs: []*Object{main, tty},
+			mem: Memory{
+				0o200: 0o1203, 0o201: 0o4777, 0o202: 0o5200, 0o203: 0o0204, 0o204: 0o0000,
+				0o377: 0o0400,
+				0o400: 0o0000, 0o401: 0o6046, 0o402: 0o5600,
+			},
+		},
+		{
+			// Main moves up a page, current page references stay the same
+			// but the pointer and the address of PUT change
+			name: "tty first",
+			objs: []*Object{tty, main},
+			mem: Memory{
+				0o200: 0o0000, 0o201: 0o6046, 0o202: 0o5600,
+				0o400: 0o1203, 0o401: 0o4777, 0o402: 0o5200, 0o403: 0o0404, 0o404: 0o0000,
+				0o577: 0o0200,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mem, err := Link(test.objs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mem, test.mem) {
+				t.Errorf("got %.4o, want %.4o", mem, test.mem)
+			}
+		})
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	main := assembleModule(t, "main", mainModule)
+	tty := assembleModule(t, "tty", ttyModule)
+	zero := assembleModule(t, "zero", "*10\n\t1\n$\n")
+	zero2 := assembleModule(t, "zero2", "*10\n\t2\n$\n")
+
+	tests := []struct {
+		name string
+		objs []*Object
+		err  string
+	}{
+		{"undefined", []*Object{main}, "undefined external symbols: PUT"},
+		{"duplicate entry", []*Object{main, tty, tty}, "PUT is an entry of both tty and tty"},
+		{"zero page", []*Object{zero, zero2}, "location 0010 is used by both zero and zero2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Link(test.objs); err == nil || err.Error() != test.err {
+				t.Errorf("got %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRelRoundTrip(t *testing.T) {
+	for _, obj := range []*Object{
+		assembleModule(t, "main", mainModule),
+		assembleModule(t, "tty", ttyModule),
+	} {
+		var b strings.Builder
+		obj.ExportRel(&b)
+		got, err := ImportRel(strings.NewReader(b.String()))
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", obj.Name, err, b.String())
+		}
+		if !reflect.DeepEqual(got, obj) {
+			t.Errorf("%s: got %+v, want %+v", obj.Name, got, obj)
+		}
+	}
+}
+
+// Library modules: tty2 needs wait, nothing needs math
+const tty2Module = `	EXTERNAL WAIT
+	ENTRY PUT
+PUT,	0
+	JMS I (WAIT)
+	TLS
+	JMP I PUT
+$
+`
+
+const waitModule = `	ENTRY WAIT
+WAIT,	0
+	TSF
+	JMP .-1
+	JMP I WAIT
+$
+`
+
+const mathModule = `	ENTRY MUL
+MUL,	0
+	JMP I MUL
+$
+`
+
+func testLibrary(t *testing.T) *Library {
+	t.Helper()
+	lib, err := NewLibrary([]*Object{
+		assembleModule(t, "math", mathModule),
+		assembleModule(t, "wait", waitModule),
+		assembleModule(t, "tty2", tty2Module),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lib
+}
+
+func TestLinkLibrary(t *testing.T) {
+	main := assembleModule(t, "main", mainModule)
+	lib := testLibrary(t)
+
+	// Modules are pulled in the order they are needed, not library order,
+	// and math is left out
+	mem, err := Link([]*Object{main}, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Memory{
+		0o200: 0o1203, 0o201: 0o4777, 0o202: 0o5200, 0o203: 0o0204, 0o204: 0o0000,
+		0o377: 0o0400,
+		0o400: 0o0000, 0o401: 0o4777, 0o402: 0o6046, 0o403: 0o5600,
+		0o577: 0o0600,
+		0o600: 0o0000, 0o601: 0o6041, 0o602: 0o5201, 0o603: 0o5600,
+	}
+	if !reflect.DeepEqual(mem, want) {
+		t.Errorf("got %.4o, want %.4o", mem, want)
+	}
+
+	// A module that is linked explicitly is used instead of the library's
+	tty := assembleModule(t, "tty", ttyModule)
+	mem, err = Link([]*Object{main, tty}, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mem) != 9 || mem[0o401] != 0o6046 {
+		t.Errorf("library module linked in place of tty: %.4o", mem)
+	}
+}
+
+func TestNewLibraryErrors(t *testing.T) {
+	tty := assembleModule(t, "tty", ttyModule)
+	tty2 := assembleModule(t, "tty2", tty2Module)
+	if _, err := NewLibrary([]*Object{tty, tty}); err == nil || err.Error() != "module tty is in the library twice" {
+		t.Errorf("got %v for a duplicate module", err)
+	}
+	if _, err := NewLibrary([]*Object{tty, tty2}); err == nil || err.Error() != "PUT is an entry of both tty and tty2" {
+		t.Errorf("got %v for a duplicate entry", err)
+	}
+}
+
+func TestLibRoundTrip(t *testing.T) {
+	lib := testLibrary(t)
+	var b strings.Builder
+	lib.ExportLib(&b)
+	got, err := ImportLib(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("%v\n%s", err, b.String())
+	}
+	if !reflect.DeepEqual(got, lib) {
+		t.Errorf("got %+v, want %+v", got, lib)
+	}
+
+	// The index has to match the modules
+	bad := strings.Replace(b.String(), "INDEX MUL math", "INDEX MUL wait", 1)
+	if _, err := ImportLib(strings.NewReader(bad)); err == nil {
+		t.Error("expected an error for an index that doesn't match")
+	}
+}
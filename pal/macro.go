@@ -0,0 +1,228 @@
+package pal
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Maximum number of macro expansions in a single pass. This stops macros that
+// expand themselves from running forever.
+const maxExpansions = 0o100000
+
+type Macro struct {
+	params []string
+	body   []Lexeme
+}
+
+// DEFINE name [param1 param2 ...] <body>
+// Define a macro. When the name is used at the start of a statement the body
+// is assembled in its place, with each parameter replaced by the arguments
+// following the name. Arguments are separated by commas:
+//
+//	DEFINE SWAP A B <
+//	        TAD A
+//	        DCA TMP
+//	        TAD B
+//	        DCA A
+//	        TAD TMP
+//	        DCA B
+//	>
+//	        SWAP X, Y
+//
+// Labels defined in the body are local to each expansion so a macro can be
+// used more than once.
+func (p *Parser) parseDefine() {
+	defineL := p.lex.This
+	p.lex.Advance()
+	if p.lex.This.Type != SYMBOL {
+		p.SyntaxError(&defineL, "expected macro name")
+		return
+	}
+	name := string(p.lex.This.Bytes)
+
+	// Parameter names can be separated by spaces or commas
+	var params []string
+	for p.lex.Next.Type == SYMBOL || (p.lex.Next.Type == PUNCTUATION && p.lex.Next.Bytes[0] == ',') {
+		p.lex.Advance()
+		if p.lex.This.Type == SYMBOL {
+			params = append(params, string(p.lex.This.Bytes))
+		}
+	}
+
+	body, ok := p.parseBlock()
+	if !ok {
+		return
+	}
+	p.macros[name] = &Macro{params, body}
+}
+
+// Parse a block of lexemes enclosed in angle brackets, which can start on a
+// following line. Blocks can be nested. The lexemes between the outermost
+// brackets are returned and the current lexeme is the closing bracket.
+func (p *Parser) parseBlock() ([]Lexeme, bool) {
+	for p.lex.Next.Type == EOL || p.lex.Next.Type == COMMENT {
+		p.lex.Advance()
+	}
+	if p.lex.Next.Type != PUNCTUATION || p.lex.Next.Bytes[0] != '<' {
+		p.SyntaxError(&p.lex.Next, "expected '<'")
+		return nil, false
+	}
+	p.lex.Advance()
+	start := p.lex.This
+
+	var block []Lexeme
+	depth := 1
+	for {
+		p.lex.Advance()
+		switch {
+		case p.lex.This.Type == EOF:
+			p.SyntaxError(&start, "unterminated block")
+			return nil, false
+		case p.lex.This.Type == PUNCTUATION && p.lex.This.Bytes[0] == '<':
+			depth++
+		case p.lex.This.Type == PUNCTUATION && p.lex.This.Bytes[0] == '>':
+			depth--
+			if depth == 0 {
+				// Cut the brackets out of the listing lines
+				end := p.lex.This
+				for i, lm := range block {
+					if lm.Line == end.Line && end.Col-1 <= len(lm.Src) {
+						block[i].Src = lm.Src[:end.Col-1]
+					}
+					if lm.Line == start.Line && start.Col <= len(block[i].Src) {
+						block[i].Src = block[i].Src[start.Col:]
+					}
+				}
+				return block, true
+			}
+		}
+		block = append(block, p.lex.This)
+	}
+}
+
+// Expand the macro named by the current lexeme. The arguments are read from
+// the rest of the line and the expanded body is pushed back into the lexer.
+func (p *Parser) expandMacro(m *Macro) {
+	call := p.lex.This
+
+	// Arguments are separated by commas up to the end of the line
+	var args [][]Lexeme
+	var arg []Lexeme
+	for p.lex.Next.Type != EOL && p.lex.Next.Type != COMMENT && p.lex.Next.Type != EOF {
+		p.lex.Advance()
+		if p.lex.This.Type == PUNCTUATION && p.lex.This.Bytes[0] == ',' {
+			args = append(args, arg)
+			arg = nil
+			continue
+		}
+		arg = append(arg, p.lex.This)
+	}
+	if arg != nil || len(args) > 0 {
+		args = append(args, arg)
+	}
+	if len(args) != len(m.params) {
+		p.SyntaxError(&call, fmt.Sprintf("macro takes %d arguments, got %d", len(m.params), len(args)))
+		return
+	}
+
+	p.expansions++
+	if p.expansions > maxExpansions {
+		if p.expansions == maxExpansions+1 {
+			p.SyntaxError(&call, "too many macro expansions, is the macro recursive?")
+		}
+		return
+	}
+
+	// Map each parameter and local label to the text that replaces it
+	subst := make(map[string][]Lexeme)
+	text := make(map[string][]byte)
+	for i, param := range m.params {
+		subst[param] = args[i]
+		text[param] = argumentText(args[i])
+	}
+	stmtStart := true
+	for i, lm := range m.body {
+		if lm.Type == EOL {
+			stmtStart = true
+			continue
+		}
+		isLabel := stmtStart && lm.Type == SYMBOL && i+1 < len(m.body) &&
+			m.body[i+1].Type == PUNCTUATION && m.body[i+1].Bytes[0] == ','
+		if isLabel {
+			// Local labels can't clash with anything in the source because
+			// symbols can't contain a '.'
+			local := lm
+			local.Bytes = []byte(string(lm.Bytes) + "." + strconv.Itoa(p.expansions))
+			subst[string(lm.Bytes)] = []Lexeme{local}
+			text[string(lm.Bytes)] = local.Bytes
+		} else if !(lm.Type == PUNCTUATION && lm.Bytes[0] == ',') {
+			stmtStart = false
+		}
+	}
+
+	// Substitute the body, keeping the listing line of each lexeme in sync
+	lines := make(map[string][]byte)
+	var expanded []Lexeme
+	for _, lm := range m.body {
+		src, ok := lines[string(lm.Src)]
+		if !ok {
+			src = substituteLine(lm.Src, text)
+			lines[string(lm.Src)] = src
+		}
+		if r, ok := subst[string(lm.Bytes)]; ok && lm.Type == SYMBOL {
+			for _, a := range r {
+				a.Src = src
+				expanded = append(expanded, a)
+			}
+			continue
+		}
+		lm.Src = src
+		expanded = append(expanded, lm)
+	}
+	p.lex.Push(expanded)
+}
+
+// Get the source text of a macro argument
+func argumentText(arg []Lexeme) []byte {
+	if len(arg) == 0 {
+		return nil
+	}
+	first, last := arg[0], arg[len(arg)-1]
+	end := last.Col - 1 + len(last.Bytes)
+	if first.Line == last.Line && end <= len(first.Src) {
+		// Copied so appending to the text can't change the source line
+		return bytes.Clone(first.Src[first.Col-1 : end])
+	}
+	// Fall back to joining the lexemes
+	var text [][]byte
+	for _, lm := range arg {
+		text = append(text, lm.Bytes)
+	}
+	return bytes.Join(text, []byte(" "))
+}
+
+// Replace whole symbols in a source line, stopping at the comment
+func substituteLine(line []byte, text map[string][]byte) []byte {
+	var out []byte
+	for i := 0; i < len(line); {
+		if line[i] == '/' {
+			return append(out, line[i:]...)
+		}
+		if !isAlphaNum(line[i]) {
+			out = append(out, line[i])
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && isAlphaNum(line[i]) {
+			i++
+		}
+		if r, ok := text[string(line[start:i])]; ok && isLetter(line[start]) {
+			out = append(out, r...)
+		} else {
+			out = append(out, line[start:i]...)
+		}
+	}
+	return out
+}
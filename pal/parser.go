@@ -0,0 +1,860 @@
+package pal
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// type InstType int
+
+// const ()
+
+// type Instruction struct {
+// 	// Location in memory of word
+// 	Loc int
+// 	// Optional label that corresponds with this memory location
+// 	Label *Symbol
+// 	// Assembled instruction
+// 	Data int
+// 	// Raw Instruction
+// 	Raw []byte
+// }
+
+type Parser struct {
+	lex        *Lexer
+	symtab     *SymbolTable
+	lc         int
+	field      int
+	radix      int // Base of numbers without a prefix, set by DECIMAL and OCTAL
+	mem        Memory
+	listing    map[int][]byte
+	tagListing map[int][]byte
+	text       map[int]string    // Listing comment of each word of packed text
+	pass       int               // Current pass, 1 collects symbols and 2 generates code
+	labels     map[string]Lexeme // Labels defined in the current pass
+	pending    []definition      // Symbol definitions that could not be resolved in pass 1
+	circular   map[string]bool   // Symbols whose definitions depend on themselves
+	forward    []forwardRef      // Origins set with symbols that were not yet defined in pass 1
+	macros     map[string]*Macro
+	expansions int                  // Number of macro expansions in the current pass
+	conditions []bool               // Results of the conditionals in pass 1
+	condIndex  int                  // Next conditional result to use in pass 2
+	pools      map[int]*literalPool // Literal pools by extended page address
+	stmt       Lexeme               // First lexeme of the statement being parsed
+	diags      []Diagnostic         // Problems found in the current pass
+	defs       map[string]Lexeme    // Where each label and symbol was defined in pass 2
+	refs       map[string][]Lexeme  // Every use of a symbol in an expression in pass 2
+	relSyms    map[string]int       // Relocatable terms in the value of each symbol
+	externs    map[string]bool      // Symbols declared EXTERNAL
+	entries    map[string]Lexeme    // Symbols declared ENTRY in pass 2
+	reloc      relocation           // Relocation of the last operand or expression parsed
+	relocs     map[int]relocation   // Words that change when the module is linked, in pass 2
+}
+
+// Literals stored from the top of a page downwards
+type literalPool struct {
+	next  int             // Address of the next free location
+	addrs map[literal]int // Location of each value, identical values are shared
+}
+
+// A literal value, literals are only shared if they are relocated the same
+type literal struct {
+	value int
+	reloc relocation
+}
+
+// How a value changes when a relocatable module is linked. Relocatable values
+// are addresses from 0200 up, the linker adds the distance the module was
+// moved to them. A value can also be the address of an external symbol plus a
+// constant.
+type relocation struct {
+	rel    int    // Relocatable terms, added ones minus subtracted ones
+	extern string // External symbol added to the value
+}
+
+// A symbol definition that referenced symbols not yet defined in pass 1
+type definition struct {
+	sym   Lexeme   // Symbol being defined
+	expr  []Lexeme // Expression the symbol is defined as
+	lc    int      // Location counter at the time of the definition
+	radix int      // Radix at the time of the definition
+	str   string   // First undefined symbol in the expression
+}
+
+// An expression that used a symbol before it was defined, where pass 2 has
+// to use the value from pass 1
+type forwardRef struct {
+	lex Lexeme
+	sym string
+	msg string
+}
+
+func NewParser(l *Lexer, st *SymbolTable) *Parser {
+	// Create our parser
+	p := &Parser{
+		lex:        l,
+		symtab:     st,
+		lc:         0o200,
+		radix:      8,
+		mem:        make(Memory),
+		listing:    make(map[int][]byte),
+		tagListing: make(map[int][]byte),
+		text:       make(map[int]string),
+		labels:     make(map[string]Lexeme),
+		circular:   make(map[string]bool),
+		macros:     make(map[string]*Macro),
+		pools:      make(map[int]*literalPool),
+		defs:       make(map[string]Lexeme),
+		refs:       make(map[string][]Lexeme),
+		relSyms:    make(map[string]int),
+		externs:    make(map[string]bool),
+		entries:    make(map[string]Lexeme),
+		relocs:     make(map[int]relocation),
+	}
+	// Problems found by the lexer are reported with the parser's
+	l.report = p.report
+	return p
+}
+
+// Assemble the source in two passes. The first pass only tracks the location
+// counter and collects label and symbol definitions, the second pass generates
+// code with every symbol known. Errors are only reported from the second pass.
+func (p *Parser) parseP8Assembly() {
+	// Pass 1: Collect symbols
+	p.pass = 1
+	p.parseSource()
+	p.resolveDefinitions()
+
+	// Pass 2: Generate code
+	p.pass = 2
+	p.ResetErrors()
+	p.lex.Reset()
+	p.lc = 0o200
+	p.field = 0
+	p.radix = 8
+	p.labels = make(map[string]Lexeme)
+	p.macros = make(map[string]*Macro)
+	p.expansions = 0
+	p.condIndex = 0
+	p.pools = make(map[int]*literalPool)
+	p.defs = make(map[string]Lexeme)
+	p.refs = make(map[string][]Lexeme)
+	p.externs = make(map[string]bool)
+	p.relocs = make(map[int]relocation)
+	p.listing = make(map[int][]byte)
+	p.tagListing = make(map[int][]byte)
+	p.text = make(map[int]string)
+	p.mem = make(Memory)
+	for _, ref := range p.forward {
+		if p.symtab.Get(ref.sym) != nil {
+			p.SyntaxError(&ref.lex, ref.msg)
+		}
+	}
+	p.parseSource()
+}
+
+// Remember a symbol that was used before it was defined in pass 1 where it
+// moves the location counter. Every location after it would be wrong in pass
+// 2, so it is reported if the symbol shows up later.
+func (p *Parser) forwardLocation(lm *Lexeme, sym, what string) {
+	if p.pass == 1 {
+		p.forward = append(p.forward, forwardRef{*lm, sym, "symbol used as " + what + " before it is defined"})
+	}
+}
+
+// Parse the source file from the current lexeme until EOF
+func (p *Parser) parseSource() {
+	for {
+		p.lex.Advance()
+		p.stmt = p.lex.This
+		// fmt.Printf("%d, %d\t[%d]\t%s\n", p.lex.This.Line, p.lex.This.Col, p.lex.This.Type, strings.TrimSpace(string(p.lex.This.Bytes)))
+
+		switch p.lex.This.Type {
+
+		case PUNCTUATION:
+			switch p.lex.This.Bytes[0] {
+
+			case '*': // Asterisk means Next sets the location counter
+				p.lex.Advance()
+				var str string
+				addrExpr := p.lex.This
+				p.lc, str = p.parseExpression()
+				if str != "" {
+					p.forwardLocation(&addrExpr, str, "program counter address")
+				}
+				// fmt.Printf("Setting location counter: %o\n", p.lc)
+
+			case '.':
+				fallthrough
+			case '(':
+				fallthrough
+			case '[':
+				fallthrough
+			case '-':
+				fallthrough
+			case '+':
+				inst, _ := p.parseExpression()
+				p.addData(inst)
+			}
+
+		case SYMBOL:
+			switch p.lex.Next.Bytes[0] {
+			case '=':
+				p.parseSymbolDefinition()
+			case ',':
+				p.parseLabel()
+
+			default:
+				if p.parsePseudoOp() {
+					break
+				}
+				if m, ok := p.macros[string(p.lex.This.Bytes)]; ok {
+					p.expandMacro(m)
+					break
+				}
+				// Lookup symbol
+				sym := p.symtab.Get(string(p.lex.This.Bytes))
+				if sym != nil && sym.Type == MRI {
+					// Memory reference instruction
+					// mriSym := p.lex.This
+					// symStr := string(p.lex.This.Bytes)
+					p.lex.Advance()
+					// var oprStr string
+					var indirect, zeroPage bool
+					link := -1 // Address of the link the operand is referenced through
+					// Check for (I)ndirect flag and (Z)ero page flag
+					for p.lex.This.Type == SYMBOL && len(p.lex.This.Bytes) == 1 && (p.lex.This.Bytes[0] == 'I' || p.lex.This.Bytes[0] == 'Z') {
+						// Check for (I)ndirect flag
+						if p.lex.This.Bytes[0] == 'I' {
+							// oprStr += "I"
+							indirect = true
+						}
+						// Check for (Z)ero page flag
+						if p.lex.This.Bytes[0] == 'Z' {
+							// oprStr += " Z"
+							zeroPage = true
+						}
+						p.lex.Advance()
+					}
+					// Parse expression of address operand
+					exprStart := p.lex.This
+					result, expr := p.parseExpression()
+					r := p.reloc
+					// Check if address is valid to reference
+					// This means either in the zero page or in the current page.
+					// In a relocatable module the current page has to move
+					// with the address when it is linked, and external
+					// symbols are never on the current page.
+					addrPage := result & 0b111110000000
+					if addrPage == 0 && r.rel == 0 && r.extern == "" {
+						// Zero page reference
+						zeroPage = true
+					} else if (addrPage != p.lc&0b111110000000 || r.rel != p.lcReloc() || r.extern != "") && expr == "" {
+						if p.lex.opts.Links && !indirect {
+							// Reference the address through a link in the
+							// literal pool of the current page
+							if addr := p.addLink(result, r, exprStart); addr != result {
+								link, result = addr, addr
+							}
+							indirect = true
+						} else if r.extern != "" {
+							p.IllegalReferenceError(&exprStart, "external symbol needs a link or literal")
+						} else {
+							// Out of page reference: throw error
+							p.IllegalReferenceError(&exprStart, "out of bounds: '"+strconv.FormatInt(int64(result), 8)+"'")
+						}
+					}
+
+					result &= 0b000001111111 // Truncate address to 7 bits
+					if !zeroPage {           // Set current page bit if not accessing zero page
+						result |= 0b000010000000
+					}
+					if indirect { // Set indirect bit
+						result |= 0b000100000000
+					}
+					result |= sym.Val
+					instAddr := p.addr()
+					p.addInstruction(result)
+					if link != -1 {
+						p.listLink(instAddr, link)
+					}
+					// fmt.Printf("MRI: %s %s %o %b\n", string(p.lex.This.Bytes), oprStr, result, result)
+				} else {
+					inst, _ := p.parseExpression()
+					p.addData(inst)
+				}
+			}
+			// if p.lex.Next.Type == PUNCTUATION && p.lex.Next.Bytes[0] != '.' { // Symbol definition
+
+			// } else { // Were using the symbol
+			// 	// p.lc++
+			// }
+
+		case NUMBER:
+			inst, _ := p.parseExpression()
+			p.addData(inst)
+			// p.lc++
+
+		case CHAR:
+			inst, _ := p.parseExpression()
+			p.addData(inst)
+
+		case STRING:
+			if !p.lex.opts.PalD {
+				p.SyntaxError(&p.lex.This, "strings require PAL-D syntax (-D)")
+				break
+			}
+			rawStr := p.lex.This.Bytes[1 : len(p.lex.This.Bytes)-1] // Raw string doesn't contain quotes
+			for i := 0; ; i++ {                                     // Place characters in consecutive memory locations
+				if i >= len(rawStr) {
+					break
+				}
+
+				c := rawStr[i]
+				if c == '\\' {
+					i++
+					switch ec := rawStr[i]; ec {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case '\\':
+						c = '\\'
+					default:
+						p.SyntaxError(&p.lex.This, "unknown character in string")
+						// panic("Unknown escaped char in string")
+					}
+				}
+				p.addInstruction(int(c))
+			}
+
+			p.addInstruction(0)
+
+		case EOF:
+			return
+		}
+	}
+}
+
+// Resolve the symbol definitions that referenced symbols defined later in the
+// source. Each pass over the pending definitions resolves at least one of them
+// or stops, whatever is left either depends on a symbol that is never defined
+// or on itself.
+func (p *Parser) resolveDefinitions() {
+	lex := p.lex
+	lc, radix := p.lc, p.radix
+	for resolved := true; resolved; {
+		resolved = false
+		remaining := p.pending[:0]
+		for _, def := range p.pending {
+			p.lex = NewReplayLexer(def.expr, lex.opts)
+			p.lex.Advance()
+			p.lc = def.lc
+			p.radix = def.radix
+			value, str := p.parseExpression()
+			if str != "" {
+				def.str = str
+				remaining = append(remaining, def)
+				continue
+			}
+			p.symtab.Set(string(def.sym.Bytes), value)
+			p.relSyms[string(def.sym.Bytes)] = p.reloc.rel
+			resolved = true
+		}
+		p.pending = remaining
+	}
+	p.lex = lex
+	p.lc, p.radix = lc, radix
+
+	// Follow the first unresolved symbol of each definition, any definition
+	// that leads back into a loop can never be resolved.
+	deps := make(map[string]string)
+	for _, def := range p.pending {
+		deps[string(def.sym.Bytes)] = def.str
+	}
+	for sym := range deps {
+		seen := make(map[string]bool)
+		for s, ok := sym, true; ok; s, ok = deps[s] {
+			if seen[s] {
+				p.circular[sym] = true
+				break
+			}
+			seen[s] = true
+		}
+	}
+}
+
+func (p *Parser) addInstruction(inst int) {
+	if pool, ok := p.pools[p.addr()&^0o177]; ok && p.lc&0o7777 > pool.next {
+		p.SyntaxError(&p.stmt, "location "+strconv.FormatInt(int64(p.lc&0o7777), 8)+" is used by a literal")
+	}
+	p.mem[p.addr()] = inst // Store instruction at memory location
+
+	// Save current line being parsed
+	line := bytes.TrimSpace(p.lex.This.Src)
+	p.listing[p.addr()] = bytes.Clone(line)
+
+	p.lc = (p.lc + 1) & 0o7777 // Increment location counter
+}
+
+// Store a word that was parsed from an expression, recording how it changes
+// when the module is linked
+func (p *Parser) addData(inst int) {
+	if (p.reloc != relocation{}) && p.checkReloc(p.reloc, &p.stmt) && p.pass == 2 {
+		p.relocs[p.addr()] = p.reloc
+	}
+	p.addInstruction(inst)
+}
+
+// Check that a value can be stored in a word of a relocatable module. Only a
+// relocatable value or an external symbol plus a constant can be relocated.
+func (p *Parser) checkReloc(r relocation, lm *Lexeme) bool {
+	if r.rel < 0 || r.rel > 1 || (r.extern != "" && r.rel != 0) {
+		p.SyntaxError(lm, "expression is not relocatable")
+		return false
+	}
+	return true
+}
+
+// Get the relocatable terms of the location counter, addresses from 0200 move
+// when a relocatable module is linked
+func (p *Parser) lcReloc() int {
+	if p.lex.opts.Relocatable && p.lc&0o7777 >= 0o200 {
+		return 1
+	}
+	return 0
+}
+
+// Get the extended address of the location counter in the current field
+func (p *Parser) addr() int {
+	return p.field<<12 | p.lc&0o7777
+}
+
+func (p *Parser) parseNumber() int {
+	var err error
+	var i64 int64
+
+	if len(p.lex.This.Bytes) > 2 && isLetter(p.lex.This.Bytes[1]) {
+		// Number base explicitly set with '0<x|o|b|d>' prefix
+
+		if p.lex.This.Bytes[1] == 'd' {
+			// Parse decimal number
+			// fmt.Println(string(p.lex.This.Bytes[2:]))
+			i64, err = strconv.ParseInt(string(p.lex.This.Bytes[2:]), 10, 16)
+		} else {
+			// ParseInt supports hex, bin, and octal automatically when passed 0 base
+			i64, err = strconv.ParseInt(string(p.lex.This.Bytes), 0, 16)
+		}
+
+	} else {
+		// Use the current radix, octal unless DECIMAL was used
+		i64, err = strconv.ParseInt(string(p.lex.This.Bytes), p.radix, 16)
+	}
+
+	if err != nil {
+		p.SyntaxError(&p.lex.This, "invalid number")
+		return 0
+	}
+	// fmt.Println("Parsed number:", string(p.lex.This.Bytes), "->", strconv.Itoa(int(i64)))
+	// fmt.Printf("NUM: %o\t%s ->\t\t%o\n", p.lc, string(p.lex.This.Bytes), int(i64))
+	return int(i64)
+}
+
+// Parse a single quoted character into its ASCII value
+func (p *Parser) parseChar() int {
+	var c byte
+	rawC := strings.Trim(string(p.lex.This.Bytes), "'")
+	switch rawC {
+	case "\\n":
+		c = '\n'
+	case "\\r":
+		c = '\r'
+	case "\\t":
+		c = '\t'
+	case "\\\\":
+		c = '\\'
+	default:
+		if len(rawC) > 1 {
+			p.SyntaxError(&p.lex.This, "unsupported escaped character")
+		}
+		c = byte(rawC[0])
+	}
+	return int(c)
+}
+
+// Parse an expression beginning at the current lexeme. Expressions are made of
+// operands separated by operators and are evaluated strictly left to right,
+// there is no operator precedence. Supported operators are:
+//
+//	A+B  Two's complement addition
+//	A-B  Two's complement subtraction
+//	A&B  Boolean AND
+//	A!B  Boolean inclusive OR
+//	A^B  Multiplication
+//	A%B  Division
+//
+// Two operands separated only by whitespace are combined with an inclusive OR,
+// this is how microinstructions are combined (e.g. 'CLA CLL'). All arithmetic
+// wraps to a 12-bit word.
+//
+// When parsing is complete the current lexeme is the last lexeme of the
+// expression. The first undefined symbol encountered is returned, or an empty
+// string if the expression could be fully evaluated.
+func (p *Parser) parseExpression() (int, string) {
+	firstL := p.lex.This
+	value, undef := p.parseOperand()
+	reloc := p.reloc
+	// Check combinations of operate microinstructions while every operand is
+	// one
+	opr := p.isOprSymbol(&firstL, value)
+
+	for {
+		var op byte
+		if p.lex.Next.Type == PUNCTUATION && isOperator(p.lex.Next.Bytes[0]) {
+			p.lex.Advance()
+			op = p.lex.This.Bytes[0]
+		} else if p.isOperand(&p.lex.Next) {
+			op = '!' // Whitespace is an implied OR
+		} else {
+			break
+		}
+		opL := p.lex.This
+		if isLineEnd(&p.lex.Next) {
+			// Don't continue the expression on the next line
+			p.SyntaxError(&opL, "missing operand after '"+string(op)+"'")
+			break
+		}
+		p.lex.Advance()
+
+		operandL := p.lex.This
+		operand, str := p.parseOperand()
+		if undef == "" {
+			undef = str
+		}
+		if opr = opr && op == '!' && p.isOprSymbol(&operandL, operand); opr {
+			if msg := checkOprCombination(value, operand); msg != "" {
+				p.SyntaxError(&operandL, msg)
+			}
+		}
+		reloc = p.combineReloc(reloc, p.reloc, op, &opL)
+
+		switch op {
+		case '+':
+			value += operand
+		case '-':
+			value -= operand
+		case '&':
+			value &= operand
+		case '!':
+			value |= operand
+		case '^':
+			value *= operand
+		case '%':
+			if operand == 0 {
+				if str == "" {
+					p.SyntaxError(&opL, "division by zero")
+				}
+				value = 0
+			} else {
+				value /= operand
+			}
+		}
+		value &= 0o7777 // Wrap to 12-bit twos-complement
+	}
+
+	if opr && p.lex.opts.CPU != nil {
+		if msg := p.lex.opts.CPU.checkInstruction(value); msg != "" {
+			p.Warning(&firstL, CodeUnsupported, msg)
+		}
+	}
+
+	p.reloc = reloc
+	return value, undef
+}
+
+// Get the relocation of two operands combined with an operator. Relocatable
+// values and external symbols can only be added and subtracted, and an
+// external symbol can't be subtracted.
+func (p *Parser) combineReloc(a, b relocation, op byte, opL *Lexeme) relocation {
+	switch {
+	case op == '+' && (a.extern == "" || b.extern == ""):
+		a.rel += b.rel
+		if a.extern == "" {
+			a.extern = b.extern
+		}
+		return a
+	case op == '-' && b.extern == "":
+		a.rel -= b.rel
+		return a
+	case a.rel == 0 && a.extern == "" && b.rel == 0 && b.extern == "":
+		return a
+	}
+	p.SyntaxError(opL, "operator can't be used with a relocatable value")
+	return relocation{}
+}
+
+// Check if an operand is a symbol for an operate instruction
+func (p *Parser) isOprSymbol(lm *Lexeme, value int) bool {
+	if lm.Type != SYMBOL || value&0o7000 != 0o7000 {
+		return false
+	}
+	sym := p.symtab.Get(string(lm.Bytes))
+	return sym != nil && sym.Type == SI
+}
+
+// Check that an operate microinstruction can be combined with the ones before
+// it. Returns an error message, or an empty string if it can.
+func checkOprCombination(prev, inst int) string {
+	// CLA (and NOP) are in every group
+	if prev&^0o200 == 0o7000 || inst&^0o200 == 0o7000 {
+		return ""
+	}
+	group := oprGroup(prev)
+	if group != oprGroup(inst) {
+		return fmt.Sprintf("can't combine group %d and group %d microinstructions", group, oprGroup(inst))
+	}
+	switch group {
+	case 1:
+		if prev&0o16 != 0 && inst&0o16 != 0 {
+			return "can't combine rotates"
+		}
+	case 2:
+		// Skips on a set condition (SMA SZA SNL) are ORed together, skips on
+		// a clear condition (SPA SNA SZL) are ANDed. They can't be mixed.
+		if prev&0o170 != 0 && inst&0o170 != 0 && prev&0o10 != inst&0o10 {
+			return "can't combine skips with opposite senses"
+		}
+	case 3:
+		if prev&0o16 != 0 && inst&0o16 != 0 {
+			return "can't combine EAE instructions"
+		}
+	}
+	return ""
+}
+
+// Check if a lexeme ends the statement, so an expression can't go on past it
+func isLineEnd(lm *Lexeme) bool {
+	return lm.Type == EOL || lm.Type == COMMENT || lm.Type == EOF
+}
+
+// Check if a lexeme can start an operand of an expression
+func (p *Parser) isOperand(lm *Lexeme) bool {
+	switch lm.Type {
+	case SYMBOL, NUMBER, CHAR:
+		return true
+	case PUNCTUATION:
+		return lm.Bytes[0] == '.' || lm.Bytes[0] == '(' || lm.Bytes[0] == '['
+	}
+	return false
+}
+
+// Parse a single operand of an expression. An operand is a symbol, number,
+// character, the current location (.) or a literal, optionally preceded by a
+// unary plus or minus. The returned value is always a 12-bit word.
+func (p *Parser) parseOperand() (int, string) {
+	p.reloc = relocation{}
+	switch p.lex.This.Type {
+
+	case SYMBOL:
+		if p.pass == 2 {
+			p.refs[string(p.lex.This.Bytes)] = append(p.refs[string(p.lex.This.Bytes)], p.lex.This)
+		}
+		if p.externs[string(p.lex.This.Bytes)] {
+			// The address is filled in by the linker
+			p.reloc.extern = string(p.lex.This.Bytes)
+			return 0, ""
+		}
+		sym := p.symtab.Get(string(p.lex.This.Bytes))
+		if sym == nil {
+			if p.pass == 2 && !p.circular[string(p.lex.This.Bytes)] {
+				p.UndefinedSymbolError(&p.lex.This, "")
+			}
+			return 0, string(p.lex.This.Bytes)
+		}
+		p.reloc.rel = p.relSyms[string(p.lex.This.Bytes)]
+		return sym.Val & 0o7777, ""
+
+	case NUMBER:
+		return p.parseNumber() & 0o7777, ""
+
+	case CHAR:
+		return p.parseChar(), ""
+
+	case UNKNOWN:
+		return 0, "" // Already reported by the lexer
+
+	case PUNCTUATION:
+		switch p.lex.This.Bytes[0] {
+		case '.': // Current location
+			p.reloc.rel = p.lcReloc()
+			return p.lc & 0o7777, ""
+
+		case '-':
+			minusL := p.lex.This
+			if isLineEnd(&p.lex.Next) {
+				p.SyntaxError(&minusL, "missing operand after '-'")
+				return 0, ""
+			}
+			p.lex.Advance()
+			value, str := p.parseOperand()
+			p.reloc = p.combineReloc(relocation{}, p.reloc, '-', &minusL)
+			return -value & 0o7777, str
+
+		case '+':
+			if isLineEnd(&p.lex.Next) {
+				p.SyntaxError(&p.lex.This, "missing operand after '+'")
+				return 0, ""
+			}
+			p.lex.Advance()
+			return p.parseOperand()
+
+		case '(', '[': // Literal stored on the current page or page 0
+			litL := p.lex.This
+			zeroPage := litL.Bytes[0] == '['
+			closing := byte(')')
+			if zeroPage {
+				closing = ']'
+			}
+			p.lex.Advance()
+			value, str := p.parseExpression()
+			if p.lex.Next.Type == PUNCTUATION && p.lex.Next.Bytes[0] == closing {
+				p.lex.Advance() // Closing bracket is optional
+			}
+			if str != "" {
+				return 0, str
+			}
+			r := p.reloc
+			if !p.checkReloc(r, &litL) {
+				r = relocation{}
+			}
+			addr := p.parseConstant(value, r, zeroPage)
+			p.reloc = relocation{}
+			if addr == -1 {
+				p.IllegalReferenceError(&litL, "no room for literal on page")
+				return 0, ""
+			}
+			if !zeroPage {
+				p.reloc.rel = p.lcReloc()
+			}
+			if _, exists := p.listing[p.field<<12|addr]; !exists {
+				p.listing[p.field<<12|addr] = argumentText([]Lexeme{litL, p.lex.This})
+			}
+			return addr, ""
+		}
+	}
+
+	p.SyntaxError(&p.lex.This, "unknown operand in expression")
+	return 0, ""
+}
+
+// Get the address of a literal in the pool of the current page, or of page 0
+// if zeroPage is set. Literals are stored from the top of the page downwards
+// and each value is only stored once per page. Returns -1 if the pool has run
+// into the code on the page.
+func (p *Parser) parseConstant(value int, r relocation, zeroPage bool) int {
+	field := p.field << 12
+	page := p.lc & 0b111110000000
+	if zeroPage {
+		page = 0
+	}
+	pool, ok := p.pools[field|page]
+	if !ok {
+		pool = &literalPool{next: page | 0b1111111, addrs: make(map[literal]int)}
+		p.pools[field|page] = pool
+	}
+	if addr, ok := pool.addrs[literal{value, r}]; ok {
+		return addr
+	}
+	if pool.next < page {
+		return -1
+	}
+	if _, used := p.mem[field|pool.next]; used {
+		return -1
+	}
+	addr := pool.next
+	pool.addrs[literal{value, r}] = addr
+	pool.next--
+	p.mem[field|addr] = value
+	if (r != relocation{}) && p.pass == 2 {
+		p.relocs[field|addr] = r
+	}
+	return addr
+}
+
+// Note in the listing of an instruction that it references its operand
+// indirectly through a link, the source line doesn't show the I
+func (p *Parser) listLink(instAddr, link int) {
+	before, after, _ := bytes.Cut(p.listing[instAddr], []byte("/"))
+	note := fmt.Sprintf("/ Indirect via link at %.4o", link)
+	if comment := bytes.TrimSpace(after); len(comment) > 0 {
+		note += ", " + string(comment)
+	}
+	p.listing[instAddr] = append(bytes.TrimSpace(before), "\t"+note...)
+}
+
+// Store an off-page address in the literal pool of the current page and return
+// the address of the link. The current lexeme is the end of the expression that
+// started at exprStart.
+func (p *Parser) addLink(target int, r relocation, exprStart Lexeme) int {
+	if !p.checkReloc(r, &exprStart) {
+		r = relocation{}
+	}
+	addr := p.parseConstant(target, r, false)
+	if addr == -1 {
+		p.IllegalReferenceError(&exprStart, "no room for link on page")
+		return target
+	}
+	p.Warning(&exprStart, CodeOffPageLink, "off-page reference, link generated at "+strconv.FormatInt(int64(addr), 8))
+	if _, exists := p.listing[p.field<<12|addr]; !exists {
+		text := argumentText([]Lexeme{exprStart, p.lex.This})
+		p.listing[p.field<<12|addr] = append(text, "\t/ Link"...)
+	}
+	return addr
+}
+
+func (p *Parser) parseSymbolDefinition() {
+	symbol := string(p.lex.This.Bytes)
+	lex := p.lex.This
+	p.lex.Advance() // Symbol to define
+	p.lex.Advance() // Equal sign '='
+	p.lex.Record()
+	value, str := p.parseExpression()
+	expr := p.lex.StopRecording()
+	if _, exists := p.defs[symbol]; !exists && p.pass == 2 {
+		p.defs[symbol] = lex
+	}
+	if p.reloc.extern != "" {
+		p.SyntaxError(&lex, "external symbol can't be used in a symbol definition")
+	} else if str == "" {
+		p.symtab.Set(symbol, int(value))
+		p.relSyms[symbol] = p.reloc.rel
+	} else if p.pass == 1 {
+		// Try again once every symbol in the file has been seen
+		p.pending = append(p.pending, definition{sym: lex, expr: expr, lc: p.lc, radix: p.radix})
+	} else if p.circular[symbol] {
+		p.SyntaxError(&lex, "circular definition")
+	}
+}
+
+func (p *Parser) parseLabel() {
+	symbol := string(p.lex.This.Bytes)
+	lex := p.lex.This
+	p.tagListing[p.addr()] = bytes.Clone(p.lex.This.Bytes)
+	p.lex.Advance() // Comma ','
+	if prev, exists := p.labels[symbol]; exists {
+		p.SyntaxError(&lex, "duplicate label")
+		p.Note(&prev, CodePrevDefinition, "label first defined here")
+	} else {
+		p.labels[symbol] = lex
+	}
+	if _, exists := p.defs[symbol]; !exists && p.pass == 2 {
+		p.defs[symbol] = lex
+	}
+	p.symtab.Label(symbol, p.lc)
+	p.relSyms[symbol] = p.lcReloc()
+	// println("label: ", symbol, " pc:", strconv.FormatInt(int64(p.lc), 8))
+}
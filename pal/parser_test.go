@@ -0,0 +1,66 @@
+package pal
+
+import (
+	"strings"
+	"testing"
+)
+
+// Assemble a source string with the default options
+func assembleString(src string) (*Program, []Diagnostic) {
+	return Assemble(strings.NewReader(src), Options{Name: "test.pa"})
+}
+
+func TestMissingOperandRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		col  int
+		msg  string
+		mem  Memory
+	}{
+		{
+			name: "trailing operator",
+			src:  "*200\n\tTAD X+\n\tHLT\nX,\t0\n$\n",
+			col:  7,
+			msg:  "missing operand after '+'",
+			mem:  Memory{0o200: 0o1202, 0o201: 0o7402, 0o202: 0},
+		},
+		{
+			name: "trailing operator before comment",
+			src:  "*200\n\tTAD X& / mask\n\tHLT\nX,\t0\n$\n",
+			col:  7,
+			msg:  "missing operand after '&'",
+			mem:  Memory{0o200: 0o1202, 0o201: 0o7402, 0o202: 0},
+		},
+		{
+			name: "trailing minus sign",
+			src:  "*200\n\t-\n\tHLT\n$\n",
+			col:  2,
+			msg:  "missing operand after '-'",
+			mem:  Memory{0o200: 0, 0o201: 0o7402},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			prog, diags := assembleString(test.src)
+			if len(diags) != 1 {
+				t.Fatalf("got %d diagnostics, want 1: %v", len(diags), diags)
+			}
+			d := diags[0]
+			if d.Code != CodeSyntax || d.Line != 2 || d.Col != test.col || !strings.Contains(d.Message, test.msg) {
+				t.Errorf("got %s at %d:%d %q, want %s at 2:%d %q", d.Code, d.Line, d.Col, d.Message, CodeSyntax, test.col, test.msg)
+			}
+			if strings.Contains(d.Message, "\n") {
+				t.Errorf("message contains a newline: %q", d.Message)
+			}
+			for addr, want := range test.mem {
+				if got, ok := prog.Mem[addr]; !ok || got != want {
+					t.Errorf("word %.4o = %.4o, want %.4o", addr, got, want)
+				}
+			}
+			if len(prog.Mem) != len(test.mem) {
+				t.Errorf("got %d words, want %d", len(prog.Mem), len(test.mem))
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package pal
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"strconv"
+)
+
+// Parse a pseudo-operation (an assembler directive) at the current lexeme.
+// Returns false if the current lexeme is not a pseudo-op.
+func (p *Parser) parsePseudoOp() bool {
+	switch string(p.lex.This.Bytes) {
+	case "FIELD":
+		p.parseField()
+	case "DEFINE":
+		p.parseDefine()
+	case "PAGE":
+		p.parsePage()
+	case "INCLUDE":
+		p.parseInclude()
+	case "IFDEF", "IFNDEF":
+		p.parseIfDef()
+	case "IFZERO", "IFNZRO":
+		p.parseIfZero()
+	case "ENTRY":
+		p.parseEntry()
+	case "EXTERNAL":
+		p.parseExternal()
+	case "TEXT", "ASCII":
+		p.parseText()
+	case "DECIMAL":
+		p.radix = 10
+	case "OCTAL":
+		p.radix = 8
+	case "ZBLOCK":
+		p.parseZBlock()
+	case "DUBL":
+		p.parseDubl()
+	case "FLTG":
+		p.parseFltg()
+	default:
+		return false
+	}
+	return true
+}
+
+// FIELD n
+// Assemble the following code into memory field n (0-7). The location counter
+// is reset to the start of the field's first page, 0200.
+func (p *Parser) parseField() {
+	if p.lex.opts.Relocatable {
+		p.SyntaxError(&p.lex.This, "FIELD can't be used in a relocatable module")
+	}
+	p.lex.Advance()
+	fieldExpr := p.lex.This
+	field, str := p.parseExpression()
+	if str != "" {
+		p.forwardLocation(&fieldExpr, str, "field number")
+		return // Undefined symbol has already been reported
+	}
+	if field > 7 {
+		p.SyntaxError(&fieldExpr, "field must be between 0 and 7")
+		return
+	}
+	p.field = field
+	p.lc = 0o200
+}
+
+// PAGE [n]
+// Move the location counter to the start of page n (0-37) of the current
+// field. Without a page number it moves to the start of the next page, unless
+// it is already at the start of a page. Literals used on the page that was
+// left stay in its pool at the top of that page.
+func (p *Parser) parsePage() {
+	if !p.isOperand(&p.lex.Next) {
+		if p.lc&0o177 != 0 {
+			p.lc = (p.lc + 0o200) & 0o7600
+		}
+		return
+	}
+	p.lex.Advance()
+	pageExpr := p.lex.This
+	page, str := p.parseExpression()
+	if str != "" {
+		p.forwardLocation(&pageExpr, str, "page number")
+		return // Undefined symbol has already been reported
+	}
+	if page > 0o37 {
+		p.SyntaxError(&pageExpr, "page must be between 0 and 37")
+		return
+	}
+	p.lc = page << 7
+}
+
+// INCLUDE "file"
+// Assemble another source file in place of the statement. Relative paths are
+// relative to the directory of the including file.
+func (p *Parser) parseInclude() {
+	includeL := p.lex.This
+	if p.lex.Next.Type != STRING {
+		p.SyntaxError(&includeL, "expected file name in double quotes")
+		return
+	}
+	p.lex.Advance()
+	nameL := p.lex.This
+	name := string(nameL.Bytes[1 : len(nameL.Bytes)-1])
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(nameL.File), name)
+	}
+	if err := p.lex.Include(name); err != nil {
+		p.SyntaxError(&nameL, "cannot include file: "+err.Error())
+	}
+}
+
+// IFDEF symbol <block>
+// IFNDEF symbol <block>
+// Assemble the block only if the symbol is defined (IFDEF) or not defined
+// (IFNDEF). Symbols have to be defined before the conditional to be seen.
+func (p *Parser) parseIfDef() {
+	ifL := p.lex.This
+	if p.lex.Next.Type != SYMBOL {
+		p.SyntaxError(&ifL, "expected symbol")
+		return
+	}
+	p.lex.Advance()
+	name := string(p.lex.This.Bytes)
+	defined := p.symtab.Get(name) != nil || p.macros[name] != nil
+	p.parseConditional(defined == (string(ifL.Bytes) == "IFDEF"))
+}
+
+// IFZERO expression <block>
+// IFNZRO expression <block>
+// Assemble the block only if the expression is zero (IFZERO) or not zero
+// (IFNZRO).
+func (p *Parser) parseIfZero() {
+	ifL := p.lex.This
+	p.lex.Advance()
+	exprL := p.lex.This
+	value, str := p.parseExpression()
+	if str != "" && p.pass == 1 {
+		// Pass 2 can't change its mind, remember the symbol so it can be
+		// reported if it shows up later.
+		p.forward = append(p.forward, forwardRef{exprL, str, "symbol used in condition before it is defined"})
+	}
+	p.parseConditional(str == "" && (value == 0) == (string(ifL.Bytes) == "IFZERO"))
+}
+
+// Parse the block of a conditional and assemble it if the condition is true.
+// Conditions are only decided in pass 1 so both passes assemble the same code,
+// even when a block defines the symbol it tests.
+func (p *Parser) parseConditional(cond bool) {
+	if p.pass == 1 {
+		p.conditions = append(p.conditions, cond)
+	} else if p.condIndex < len(p.conditions) {
+		cond = p.conditions[p.condIndex]
+		p.condIndex++
+	}
+	block, ok := p.parseBlock()
+	if ok && cond {
+		p.lex.Push(block)
+	}
+}
+
+// ZBLOCK n
+// Store n words of zero starting at the current location.
+func (p *Parser) parseZBlock() {
+	zblockL := p.lex.This
+	if !p.isOperand(&p.lex.Next) {
+		p.SyntaxError(&zblockL, "expected number of words")
+		return
+	}
+	p.lex.Advance()
+	countL := p.lex.This
+	n, str := p.parseExpression()
+	if str != "" {
+		p.forwardLocation(&countL, str, "ZBLOCK size")
+		return // Undefined symbol has already been reported
+	}
+	start := p.addr()
+	for i := 0; i < n; i++ {
+		p.addInstruction(0)
+	}
+	p.listOnce(start)
+}
+
+// DUBL n [n...]
+// Store 24-bit integers in two words each, high-order word first. The numbers
+// are always decimal and can be negative. Several numbers can be given on one
+// line, separated by spaces or commas.
+func (p *Parser) parseDubl() {
+	dublL := p.lex.This
+	start := p.addr()
+	count := 0
+	for {
+		next := p.lex.Next
+		if next.Type == PUNCTUATION && next.Bytes[0] == ',' {
+			p.lex.Advance()
+			continue
+		}
+		if next.Type != NUMBER && !(next.Type == PUNCTUATION && (next.Bytes[0] == '-' || next.Bytes[0] == '+')) {
+			break
+		}
+		p.lex.Advance()
+		numL := p.lex.This
+		sign := int64(1)
+		if numL.Type == PUNCTUATION {
+			if numL.Bytes[0] == '-' {
+				sign = -1
+			}
+			if p.lex.Next.Type != NUMBER {
+				p.SyntaxError(&numL, "expected number after sign")
+				return
+			}
+			p.lex.Advance()
+		}
+		n, err := strconv.ParseInt(string(p.lex.This.Bytes), 10, 32)
+		if err != nil {
+			p.SyntaxError(&p.lex.This, "invalid decimal number")
+			n = 0
+		}
+		n *= sign
+		if n < -0o40000000 || n > 0o77777777 {
+			p.SyntaxError(&p.lex.This, "number doesn't fit in 24 bits")
+			n = 0
+		}
+		p.addInstruction(int(n>>12) & 0o7777)
+		p.addInstruction(int(n) & 0o7777)
+		count++
+	}
+	if count == 0 {
+		p.SyntaxError(&dublL, "expected decimal numbers")
+	}
+	p.listOnce(start)
+}
+
+// FLTG n [n...]
+// Store decimal floating point numbers (3.14159, -1.5E-3) in the three word
+// format of the Floating Point Package: an exponent word followed by a 24-bit
+// mantissa. With the FPP-12 option they are stored in its six word extended
+// precision format, with a 60-bit mantissa.
+func (p *Parser) parseFltg() {
+	fltgL := p.lex.This
+	start := p.addr()
+	mantWords := 2
+	if p.lex.opts.FPP12 {
+		mantWords = 5
+	}
+	count := 0
+	for p.lex.Next.Type == FLOAT || (p.lex.Next.Type == PUNCTUATION && p.lex.Next.Bytes[0] == ',') {
+		p.lex.Advance()
+		if p.lex.This.Type != FLOAT {
+			continue
+		}
+		words, err := floatWords(string(p.lex.This.Bytes), mantWords)
+		if err != nil {
+			p.SyntaxError(&p.lex.This, err.Error())
+			words = make([]int, mantWords+1)
+		}
+		for _, word := range words {
+			p.addInstruction(word)
+		}
+		count++
+	}
+	if count == 0 {
+		p.SyntaxError(&fltgL, "expected floating point numbers")
+	}
+	p.listOnce(start)
+}
+
+// Convert a decimal number to floating point words: the exponent followed by
+// a two's complement mantissa with the binary point after its sign bit. The
+// mantissa is normalized so its first bit after the sign differs from the
+// sign, zero is stored as all zero words.
+func floatWords(num string, mantWords int) ([]int, error) {
+	bits := mantWords*12 - 1 // Bits of the mantissa after the sign
+	f, _, err := big.ParseFloat(num, 10, uint(bits), big.ToNearestEven)
+	if err != nil {
+		return nil, errors.New("invalid floating point number")
+	}
+	words := make([]int, mantWords+1)
+	if f.Sign() == 0 {
+		return words, nil
+	}
+
+	// The magnitude is rounded to fit, 0.5 <= |frac| < 1
+	frac := new(big.Float)
+	exp := f.MantExp(frac)
+	mant, _ := frac.SetMantExp(frac, bits).Int(nil)
+	if mant.Sign() < 0 && mant.Cmp(new(big.Int).Lsh(big.NewInt(-1), uint(bits-1))) == 0 {
+		// -0.5 isn't normalized, it is stored as -1.0 with a smaller exponent
+		mant.Lsh(mant, 1)
+		exp--
+	}
+	if exp < -0o4000 || exp > 0o3777 {
+		return nil, errors.New("floating point number out of range")
+	}
+
+	words[0] = exp & 0o7777
+	if mant.Sign() < 0 {
+		mant.Add(mant, new(big.Int).Lsh(big.NewInt(1), uint(bits+1)))
+	}
+	for i := mantWords; i > 0; i-- {
+		words[i] = int(mant.Int64() & 0o7777)
+		mant.Rsh(mant, 12)
+	}
+	return words, nil
+}
+
+// Show the source line of a statement that stored several words only next to
+// its first word, which is at start, in the listing
+func (p *Parser) listOnce(start int) {
+	for addr := start + 1; addr < p.addr(); addr++ {
+		p.listing[addr] = nil
+	}
+}
+
+// ENTRY symbol[, symbol...]
+// Make symbols defined in a relocatable module available to the modules it is
+// linked with. Outside of a relocatable module the symbols are only checked.
+func (p *Parser) parseEntry() {
+	for _, lm := range p.parseSymbolList() {
+		name := string(lm.Bytes)
+		if p.externs[name] {
+			p.SyntaxError(&lm, "EXTERNAL symbol can't be an ENTRY")
+		} else if p.pass == 2 && p.symtab.Get(name) == nil {
+			p.UndefinedSymbolError(&lm, "")
+		} else if p.pass == 2 {
+			p.entries[name] = lm
+		}
+	}
+}
+
+// EXTERNAL symbol[, symbol...]
+// Declare symbols that are defined in another relocatable module. Their
+// addresses are filled in when the modules are linked, so they can only be
+// used as a whole word, in a literal or through a link.
+func (p *Parser) parseExternal() {
+	externalL := p.lex.This
+	if !p.lex.opts.Relocatable {
+		p.SyntaxError(&externalL, "EXTERNAL needs a relocatable module (-rel)")
+	}
+	for _, lm := range p.parseSymbolList() {
+		name := string(lm.Bytes)
+		if p.pass == 2 && p.symtab.Get(name) != nil {
+			p.SyntaxError(&lm, "EXTERNAL symbol is defined in this module")
+			continue
+		}
+		p.externs[name] = true
+	}
+}
+
+// Parse symbols separated by spaces or commas up to the end of the line
+func (p *Parser) parseSymbolList() []Lexeme {
+	startL := p.lex.This
+	var syms []Lexeme
+	for p.lex.Next.Type == SYMBOL || (p.lex.Next.Type == PUNCTUATION && p.lex.Next.Bytes[0] == ',') {
+		p.lex.Advance()
+		if p.lex.This.Type == SYMBOL {
+			syms = append(syms, p.lex.This)
+		}
+	}
+	if len(syms) == 0 {
+		p.SyntaxError(&startL, "expected symbol names")
+	}
+	return syms
+}
+
+// TEXT /string/
+// Store a string as 6-bit characters, two to a word, followed by a zero
+// character. Lower case letters are stored as upper case. The string can be
+// delimited by any character that isn't in it.
+//
+// ASCII /string/
+// Store a string as 8-bit characters packed three to two words, followed by a
+// zero character. The first two characters are the right halves of the words
+// and the third is split across their left halves, high bits first.
+func (p *Parser) parseText() {
+	textL := p.lex.This
+	if p.lex.Next.Type == UNKNOWN {
+		return // Unterminated text has already been reported
+	}
+	if p.lex.Next.Type != TEXT {
+		p.SyntaxError(&textL, "expected text between delimiters")
+		return
+	}
+	p.lex.Advance()
+	str := p.lex.This.Bytes[1 : len(p.lex.This.Bytes)-1]
+	if string(textL.Bytes) == "TEXT" {
+		p.addSixbit(str)
+	} else {
+		p.addPackedASCII(str)
+	}
+}
+
+// Store 6-bit text two characters to a word. A string with an odd length ends
+// in the right half of its last word, otherwise a zero word is added.
+func (p *Parser) addSixbit(str []byte) {
+	chars := append(bytes.ToUpper(str), 0)
+	if len(chars)%2 != 0 {
+		chars = append(chars, 0)
+	}
+	for i, c := range chars {
+		if c != 0 && (c < ' ' || c > '_') {
+			p.SyntaxError(&p.lex.This, "character "+strconv.QuoteRune(rune(c))+" can't be stored in 6 bits")
+			chars[i] = 0
+		}
+	}
+	for i := 0; i < len(chars); i += 2 {
+		p.text[p.addr()] = textComment(chars[i : i+2])
+		p.addInstruction(int(chars[i]&0o77)<<6 | int(chars[i+1]&0o77))
+	}
+}
+
+// Store 8-bit text three characters to two words, padded with zero characters
+// to a whole number of pairs. The characters of a pair are shown next to its
+// first word in the listing.
+func (p *Parser) addPackedASCII(str []byte) {
+	chars := append(bytes.Clone(str), 0)
+	for len(chars)%3 != 0 {
+		chars = append(chars, 0)
+	}
+	for i := 0; i < len(chars); i += 3 {
+		c1, c2, c3 := int(chars[i]), int(chars[i+1]), int(chars[i+2])
+		p.text[p.addr()] = textComment(chars[i : i+3])
+		p.addInstruction(c3>>4<<8 | c1)
+		p.text[p.addr()] = ""
+		p.addInstruction(c3&0o17<<8 | c2)
+	}
+}
+
+// Get the listing comment of packed characters, zero characters aren't shown
+func textComment(chars []byte) string {
+	chars = bytes.TrimRight(chars, "\x00")
+	if len(chars) == 0 {
+		return "NULL"
+	}
+	return strconv.Quote(string(chars))
+}
@@ -0,0 +1,146 @@
+package pal
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Assemble a source and get its words in address order
+func assembleWords(t *testing.T, src string, opts Options) []int {
+	t.Helper()
+	opts.Name = "test.pa"
+	prog, diags := Assemble(strings.NewReader(src), opts)
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	addrs := make([]int, 0, len(prog.Mem))
+	for addr := range prog.Mem {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	words := make([]int, len(addrs))
+	for i, addr := range addrs {
+		words[i] = prog.Mem[addr]
+	}
+	return words
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		src   string
+		words []int
+	}{
+		// Two 6-bit characters to a word, ending with a zero character
+		{"TEXT /Hello/", []int{0o1005, 0o1414, 0o1700}},
+		{"TEXT \"ABCD\"", []int{0o0102, 0o0304, 0o0000}},
+		{"TEXT |A/B|", []int{0o0157, 0o0200}},
+		{"TEXT //", []int{0o0000}},
+
+		// Three 8-bit characters to two words, the third split across the
+		// left halves
+		{"ASCII |Hi there|", []int{0o1110, 0o0151, 0o3164, 0o2550, 0o0162, 0o0145}},
+		{"ASCII /abc/", []int{0o3141, 0o1542, 0o0000, 0o0000}},
+		{"ASCII //", []int{0o0000, 0o0000}},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			words := assembleWords(t, "*200\n\t"+test.src+"\n$\n", Options{})
+			if !reflect.DeepEqual(words, test.words) {
+				t.Errorf("got %.4o, want %.4o", words, test.words)
+			}
+		})
+	}
+}
+
+func TestDubl(t *testing.T) {
+	tests := []struct {
+		src   string
+		words []int
+	}{
+		// High-order word first, numbers are decimal even after OCTAL
+		{"DUBL 0", []int{0o0000, 0o0000}},
+		{"DUBL 1", []int{0o0000, 0o0001}},
+		{"DUBL 4096", []int{0o0001, 0o0000}},
+		{"DUBL 100000", []int{0o0030, 0o3240}},
+		{"DUBL -1", []int{0o7777, 0o7777}},
+		{"DUBL 8388607", []int{0o3777, 0o7777}},
+		{"DUBL -8388608", []int{0o4000, 0o0000}},
+		{"DUBL 16777215", []int{0o7777, 0o7777}},
+		{"DUBL 1 -2, +3", []int{0o0000, 0o0001, 0o7777, 0o7776, 0o0000, 0o0003}},
+		{"OCTAL\n\tDUBL 10", []int{0o0000, 0o0012}},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			words := assembleWords(t, "*200\n\t"+test.src+"\n$\n", Options{})
+			if !reflect.DeepEqual(words, test.words) {
+				t.Errorf("got %.4o, want %.4o", words, test.words)
+			}
+		})
+	}
+}
+
+func TestRadixAndZBlock(t *testing.T) {
+	src := "*200\n\t10\n\tDECIMAL\n\t10\n\t0o10\n\tZBLOCK 2\n\tOCTAL\n\t10\n$\n"
+	want := []int{0o0010, 0o0012, 0o0010, 0o0000, 0o0000, 0o0010}
+	if words := assembleWords(t, src, Options{}); !reflect.DeepEqual(words, want) {
+		t.Errorf("got %.4o, want %.4o", words, want)
+	}
+}
+
+func TestFloatWords(t *testing.T) {
+	tests := []struct {
+		num string
+		fpp []int // Floating Point Package, 24-bit mantissa
+		ep  []int // FPP-12 extended precision, 60-bit mantissa
+	}{
+		{"0", []int{0o0000, 0o0000, 0o0000}, []int{0o0000, 0o0000, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"1.0", []int{0o0001, 0o2000, 0o0000}, []int{0o0001, 0o2000, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"0.5", []int{0o0000, 0o2000, 0o0000}, []int{0o0000, 0o2000, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"-1.0", []int{0o0000, 0o4000, 0o0000}, []int{0o0000, 0o4000, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"-0.5", []int{0o7777, 0o4000, 0o0000}, []int{0o7777, 0o4000, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"-0.75", []int{0o0000, 0o5000, 0o0000}, []int{0o0000, 0o5000, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"2E3", []int{0o0013, 0o3720, 0o0000}, []int{0o0013, 0o3720, 0o0000, 0o0000, 0o0000, 0o0000}},
+		{"3.14159", []int{0o0002, 0o3110, 0o3750}, []int{0o0002, 0o3110, 0o3747, 0o6006, 0o7031, 0o5621}},
+		{"-1.5E-3", []int{0o7767, 0o4733, 0o1055}, []int{0o7767, 0o4733, 0o1055, 0o0345, 0o3004, 0o0611}},
+		{"0.1", []int{0o7775, 0o3146, 0o3146}, []int{0o7775, 0o3146, 0o3146, 0o3146, 0o3146, 0o3146}},
+	}
+	for _, test := range tests {
+		t.Run(test.num, func(t *testing.T) {
+			if words, err := floatWords(test.num, 2); err != nil || !reflect.DeepEqual(words, test.fpp) {
+				t.Errorf("got %.4o (%v), want %.4o", words, err, test.fpp)
+			}
+			if words, err := floatWords(test.num, 5); err != nil || !reflect.DeepEqual(words, test.ep) {
+				t.Errorf("FPP-12: got %.4o (%v), want %.4o", words, err, test.ep)
+			}
+		})
+	}
+
+	for _, num := range []string{"1.2.3", "-", "1E9999"} {
+		if _, err := floatWords(num, 2); err == nil {
+			t.Errorf("%s: expected an error", num)
+		}
+	}
+}
+
+func TestFltg(t *testing.T) {
+	src := "*200\n\tFLTG 1.0, -0.5 .25\n$\n"
+	want := []int{0o0001, 0o2000, 0o0000, 0o7777, 0o4000, 0o0000, 0o7777, 0o2000, 0o0000}
+	if words := assembleWords(t, src, Options{}); !reflect.DeepEqual(words, want) {
+		t.Errorf("got %.4o, want %.4o", words, want)
+	}
+
+	src = "*200\n\tFLTG 1.0\n$\n"
+	want = []int{0o0001, 0o2000, 0o0000, 0o0000, 0o0000, 0o0000}
+	if words := assembleWords(t, src, Options{FPP12: true}); !reflect.DeepEqual(words, want) {
+		t.Errorf("FPP-12: got %.4o, want %.4o", words, want)
+	}
+}
+
+func TestZBlockForwardSize(t *testing.T) {
+	_, diags := Assemble(strings.NewReader("*200\n\tJMP END\n\tZBLOCK N\nEND,\tHLT\nN=2\n$\n"), Options{Name: "test.pa"})
+	if len(diags) != 1 || diags[0].Line != 3 || !strings.Contains(diags[0].Message, "ZBLOCK size") {
+		t.Errorf("got %v, want an error for the ZBLOCK size on line 3", diags)
+	}
+}
@@ -1,4 +1,14 @@
-package main
+package pal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 type SymType int
 
@@ -8,6 +18,29 @@ const (
 	LABEL
 )
 
+func (t SymType) String() string {
+	switch t {
+	case MRI:
+		return "MRI"
+	case LABEL:
+		return "LABEL"
+	}
+	return "SI"
+}
+
+// Get a symbol type from its name
+func parseSymType(name string) (SymType, bool) {
+	switch strings.ToUpper(name) {
+	case "SI":
+		return SI, true
+	case "MRI":
+		return MRI, true
+	case "LABEL":
+		return LABEL, true
+	}
+	return SI, false
+}
+
 type Symbol struct {
 	Type SymType
 	Val  int
@@ -15,6 +48,15 @@ type Symbol struct {
 
 type SymbolTable map[string]Symbol
 
+// Make a copy of the table that can be changed without changing this one
+func (st *SymbolTable) Copy() *SymbolTable {
+	c := make(SymbolTable, len(*st))
+	for name, sym := range *st {
+		c[name] = sym
+	}
+	return &c
+}
+
 func (st *SymbolTable) Get(symbol string) *Symbol {
 	if sym, exists := (*st)[symbol]; exists {
 		// fmt.Printf("Found symbol: %v\n", sym)
@@ -48,6 +90,95 @@ func (st *SymbolTable) Label(symbol string, val int) (redef bool) {
 	return
 }
 
+// A symbol in a symbol table file in JSON format, the value is in octal
+type jsonSymbol struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Read symbols from a symbol table file into the table. The text format has a
+// symbol on each line as NAME TYPE VALUE, with the value in octal. Comments
+// start with a '/' like in PAL:
+//
+//	DTRA  SI   6761    / DECtape read status register A
+//
+// The JSON format is a list of objects with the same fields:
+//
+//	[{"name": "DTRA", "type": "SI", "value": "6761"}]
+func (st *SymbolTable) ImportSymbols(r io.Reader, isJSON bool) error {
+	if isJSON {
+		var syms []jsonSymbol
+		if err := json.NewDecoder(r).Decode(&syms); err != nil {
+			return err
+		}
+		for i, sym := range syms {
+			if err := st.define(sym.Name, sym.Type, sym.Value); err != nil {
+				return fmt.Errorf("symbol %d: %v", i+1, err)
+			}
+		}
+		return nil
+	}
+
+	s := bufio.NewScanner(r)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line, _, _ := strings.Cut(s.Text(), "/")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return fmt.Errorf("line %d: expected NAME TYPE VALUE", lineNum)
+		}
+		if err := st.define(fields[0], fields[1], fields[2]); err != nil {
+			return fmt.Errorf("line %d: %v", lineNum, err)
+		}
+	}
+	return s.Err()
+}
+
+// Add a symbol to the table from the fields of a symbol table file
+func (st *SymbolTable) define(name, typ, value string) error {
+	if !IsSymbolName(name) {
+		return fmt.Errorf("invalid symbol name: %s", name)
+	}
+	symType, ok := parseSymType(typ)
+	if !ok {
+		return fmt.Errorf("invalid symbol type: %s", typ)
+	}
+	val, err := strconv.ParseInt(value, 8, 16)
+	if err != nil || val > 0o7777 {
+		return fmt.Errorf("invalid octal value: %s", value)
+	}
+	(*st)[name] = Symbol{symType, int(val)}
+	return nil
+}
+
+// Write the table in the format read by ImportSymbols, sorted by name
+func (st *SymbolTable) ExportSymbols(w io.Writer, isJSON bool) {
+	names := make([]string, 0, len(*st))
+	for name := range *st {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if isJSON {
+		syms := make([]jsonSymbol, 0, len(names))
+		for _, name := range names {
+			sym := (*st)[name]
+			syms = append(syms, jsonSymbol{name, sym.Type.String(), fmt.Sprintf("%.4o", sym.Val)})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(syms)
+		return
+	}
+	for _, name := range names {
+		sym := (*st)[name]
+		fmt.Fprintf(w, "%-8s%-8s%.4o\n", name, sym.Type, sym.Val)
+	}
+}
+
 var default_symbols SymbolTable = SymbolTable{
 	// Memory reference instructions
 	"AND": Symbol{MRI, 0},
@@ -72,6 +203,7 @@ var default_symbols SymbolTable = SymbolTable{
 	"CLA": Symbol{SI, 0o7200}, // This does the same thing as 0o7600 - pick your favorite
 	"GLK": Symbol{SI, 0o7204},
 	"STA": Symbol{SI, 0o7240},
+	"BSW": Symbol{SI, 0o7002},
 
 	// Group 2 operate instructions
 	"HLT": Symbol{SI, 0o7402},
@@ -86,6 +218,21 @@ var default_symbols SymbolTable = SymbolTable{
 	// "CLA": Symbol{SI, 0o7600}, // This does the same thing as 0o7200 - pick your favorite
 	"LAS": Symbol{SI, 0o7604},
 
+	// Group 3 operate instructions (MQ register)
+	"MQA": Symbol{SI, 0o7501},
+	"MQL": Symbol{SI, 0o7421},
+	"SWP": Symbol{SI, 0o7521},
+	"CAM": Symbol{SI, 0o7621},
+
+	// Group 3 operate instructions (KE8-E extended arithmetic element, mode A)
+	"SCL": Symbol{SI, 0o7403},
+	"MUY": Symbol{SI, 0o7405},
+	"DVI": Symbol{SI, 0o7407},
+	"NMI": Symbol{SI, 0o7411},
+	"SHL": Symbol{SI, 0o7413},
+	"ASR": Symbol{SI, 0o7415},
+	"LSR": Symbol{SI, 0o7417},
+
 	// IOT - Program Interrupt
 	"ION": Symbol{SI, 0o6001},
 	"IOF": Symbol{SI, 0o6002},
@@ -112,6 +259,14 @@ var default_symbols SymbolTable = SymbolTable{
 	"TCF": Symbol{SI, 0o6042},
 	"TPC": Symbol{SI, 0o6044},
 	"TLS": Symbol{SI, 0o6046},
+
+	// IOT - Memory Extension and Time Share (KM8-E)
+	"CDF": Symbol{SI, 0o6201},
+	"CIF": Symbol{SI, 0o6202},
+	"RDF": Symbol{SI, 0o6214},
+	"RIF": Symbol{SI, 0o6224},
+	"RIB": Symbol{SI, 0o6234},
+	"RMF": Symbol{SI, 0o6244},
 }
 
 // I accidently swapped the IR bits in the instruction decoder for the MK-12. OOPS!
@@ -139,6 +294,7 @@ var mk_symbols SymbolTable = SymbolTable{
 	"CLA": Symbol{SI, 0o7200}, // This does the same thing as 0o7600 - pick your favorite
 	"GLK": Symbol{SI, 0o7204},
 	"STA": Symbol{SI, 0o7240},
+	"BSW": Symbol{SI, 0o7002},
 
 	// Group 2 operate instructions
 	"HLT": Symbol{SI, 0o7402},
@@ -153,6 +309,21 @@ var mk_symbols SymbolTable = SymbolTable{
 	// "CLA": Symbol{SI, 0o7600}, // This does the same thing as 0o7200 - pick your favorite
 	"LAS": Symbol{SI, 0o7604},
 
+	// Group 3 operate instructions (MQ register)
+	"MQA": Symbol{SI, 0o7501},
+	"MQL": Symbol{SI, 0o7421},
+	"SWP": Symbol{SI, 0o7521},
+	"CAM": Symbol{SI, 0o7621},
+
+	// Group 3 operate instructions (KE8-E extended arithmetic element, mode A)
+	"SCL": Symbol{SI, 0o7403},
+	"MUY": Symbol{SI, 0o7405},
+	"DVI": Symbol{SI, 0o7407},
+	"NMI": Symbol{SI, 0o7411},
+	"SHL": Symbol{SI, 0o7413},
+	"ASR": Symbol{SI, 0o7415},
+	"LSR": Symbol{SI, 0o7417},
+
 	// IOT - Program Interrupt
 	"ION": Symbol{SI, 0o3001},
 	"IOF": Symbol{SI, 0o3002},
@@ -179,4 +350,12 @@ var mk_symbols SymbolTable = SymbolTable{
 	"TCF": Symbol{SI, 0o3042},
 	"TPC": Symbol{SI, 0o3044},
 	"TLS": Symbol{SI, 0o3046},
+
+	// IOT - Memory Extension and Time Share (KM8-E)
+	"CDF": Symbol{SI, 0o3201},
+	"CIF": Symbol{SI, 0o3202},
+	"RDF": Symbol{SI, 0o3214},
+	"RIF": Symbol{SI, 0o3224},
+	"RIB": Symbol{SI, 0o3234},
+	"RMF": Symbol{SI, 0o3244},
 }
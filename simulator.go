@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/Rex--/mkasm/pal"
+)
+
+// CPU is an instruction level model of a PDP-8 with a KM8-E memory extension,
+// MQ register and a teletype attached to the console reader and writer.
+type CPU struct {
+	AC int // Accumulator
+	L  int // Link
+	PC int // Program counter
+	MQ int // Multiplier quotient
+	SR int // Switch register
+
+	IF int // Instruction field
+	DF int // Data field
+	IB int // Instruction buffer, loaded into IF on the next JMP or JMS
+	SF int // Save field, holds IF and DF during an interrupt
+
+	// Swap the MRI opcode bits the same way the MK-12 instruction decoder does
+	SwapIR bool
+
+	// Memory for all 8 fields
+	mem [0o100000]int
+
+	// Interrupt state
+	ion      bool // Interrupts enabled
+	ionDelay bool // Interrupts are enabled after the next instruction
+	cifDelay bool // Interrupts are held off until the next JMP or JMS
+
+	// Teletype state
+	in      *bufio.Reader
+	out     io.Writer
+	kbdFlag bool
+	kbdBuf  int
+	kbdEOF  bool
+	ttyFlag bool
+
+	Halted bool
+	Reason string // Why the CPU halted
+	Steps  int    // Number of instructions executed
+}
+
+func NewCPU(in io.Reader, out io.Writer) *CPU {
+	return &CPU{
+		in:  bufio.NewReader(in),
+		out: out,
+		PC:  0o200,
+	}
+}
+
+// Load every word of an assembled program into memory
+func (c *CPU) Load(m pal.Memory) {
+	for addr, inst := range m {
+		c.mem[addr&0o77777] = inst & 0o7777
+	}
+}
+
+// Run the CPU until it halts. A maximum number of instructions can be given to
+// stop programs that never halt, 0 means there is no limit.
+func (c *CPU) Run(maxSteps int) {
+	c.Halted = false
+	for !c.Halted {
+		if maxSteps > 0 && c.Steps >= maxSteps {
+			c.Halted = true
+			c.Reason = "instruction limit reached"
+			break
+		}
+		c.Step()
+	}
+}
+
+// Execute a single instruction
+func (c *CPU) Step() {
+	// Take an interrupt before fetching the next instruction
+	if c.ion && !c.cifDelay && (c.kbdFlag || c.ttyFlag) {
+		c.ion = false
+		c.SF = c.IF<<3 | c.DF
+		c.mem[0] = c.PC
+		c.IF, c.IB, c.DF = 0, 0, 0
+		c.PC = 1
+	}
+	if c.ionDelay {
+		c.ionDelay = false
+		c.ion = true
+	}
+
+	inst := c.mem[c.IF<<12|c.PC]
+	c.PC = (c.PC + 1) & 0o7777
+	c.Steps++
+
+	opcode := inst >> 9
+	if c.SwapIR {
+		// IR bits 0 and 2 are swapped on the MK-12
+		opcode = (opcode&0b001)<<2 | opcode&0b010 | (opcode&0b100)>>2
+	}
+
+	switch opcode {
+	case 0, 1, 2, 3, 4, 5:
+		c.executeMRI(opcode, inst)
+	case 6:
+		c.executeIOT(inst)
+	case 7:
+		if inst&0o400 == 0 {
+			c.executeGroup1(inst)
+		} else if inst&0o1 == 0 {
+			c.executeGroup2(inst)
+		} else {
+			c.executeGroup3(inst)
+		}
+	}
+}
+
+// Memory reference instructions
+func (c *CPU) executeMRI(opcode, inst int) {
+	// Get the effective address
+	addr := inst & 0o177
+	if inst&0o200 != 0 { // Current page
+		addr |= (c.PC - 1) & 0o7600
+	}
+	field := c.IF
+	if inst&0o400 != 0 { // Indirect
+		ptr := c.IF<<12 | addr
+		if addr >= 0o10 && addr <= 0o17 { // Auto-index
+			c.mem[ptr] = (c.mem[ptr] + 1) & 0o7777
+		}
+		addr = c.mem[ptr]
+		field = c.DF
+	}
+
+	switch opcode {
+	case 0: // AND
+		c.AC &= c.mem[field<<12|addr]
+	case 1: // TAD
+		c.AC += c.mem[field<<12|addr]
+		if c.AC > 0o7777 {
+			c.L ^= 1
+		}
+		c.AC &= 0o7777
+	case 2: // ISZ
+		val := (c.mem[field<<12|addr] + 1) & 0o7777
+		c.mem[field<<12|addr] = val
+		if val == 0 {
+			c.PC = (c.PC + 1) & 0o7777
+		}
+	case 3: // DCA
+		c.mem[field<<12|addr] = c.AC
+		c.AC = 0
+	case 4: // JMS
+		c.IF = c.IB
+		c.cifDelay = false
+		c.mem[c.IF<<12|addr] = c.PC
+		c.PC = (addr + 1) & 0o7777
+	case 5: // JMP
+		c.IF = c.IB
+		c.cifDelay = false
+		c.PC = addr
+	}
+}
+
+// Input/Output transfer instructions
+func (c *CPU) executeIOT(inst int) {
+	device := (inst >> 3) & 0o77
+	function := inst & 0o7
+
+	switch {
+	case device == 0: // Program interrupt
+		switch function {
+		case 1: // ION
+			c.ionDelay = true
+		case 2: // IOF
+			c.ion = false
+			c.ionDelay = false
+		}
+
+	case device == 0o3: // Teletype keyboard/reader
+		if function&0o1 != 0 { // KSF
+			c.pollKeyboard()
+			if c.kbdFlag {
+				c.skip()
+			}
+		}
+		if function&0o2 != 0 { // KCC
+			c.AC = 0
+			c.kbdFlag = false
+		}
+		if function&0o4 != 0 { // KRS
+			c.AC |= c.kbdBuf
+		}
+
+	case device == 0o4: // Teletype teleprinter/punch
+		if function&0o1 != 0 && c.ttyFlag { // TSF
+			c.skip()
+		}
+		if function&0o2 != 0 { // TCF
+			c.ttyFlag = false
+		}
+		if function&0o4 != 0 { // TPC
+			c.out.Write([]byte{byte(c.AC & 0o177)})
+			c.ttyFlag = true // Output is instant
+		}
+
+	case device&0o70 == 0o20: // Memory extension
+		field := device & 0o7
+		switch function {
+		case 1: // CDF
+			c.DF = field
+		case 2: // CIF
+			c.IB = field
+			c.cifDelay = true
+		case 3: // CDF CIF
+			c.DF = field
+			c.IB = field
+			c.cifDelay = true
+		case 4:
+			switch field {
+			case 1: // RDF
+				c.AC |= c.DF << 3
+			case 2: // RIF
+				c.AC |= c.IF << 3
+			case 3: // RIB
+				c.AC |= c.SF
+			case 4: // RMF
+				c.IB = (c.SF >> 3) & 0o7
+				c.DF = c.SF & 0o7
+				c.cifDelay = true
+			}
+		}
+	}
+}
+
+// Read the next character from the keyboard if one isn't waiting
+func (c *CPU) pollKeyboard() {
+	if c.kbdFlag {
+		return
+	}
+	if c.kbdEOF {
+		// Nothing will ever be typed, stop instead of spinning forever
+		c.Halted = true
+		c.Reason = "end of keyboard input"
+		return
+	}
+	b, err := c.in.ReadByte()
+	if err != nil {
+		c.kbdEOF = true
+		return
+	}
+	c.kbdBuf = int(b) | 0o200 // Teletypes send mark parity
+	c.kbdFlag = true
+}
+
+// Group 1 operate microinstructions
+func (c *CPU) executeGroup1(inst int) {
+	if inst&0o200 != 0 { // CLA
+		c.AC = 0
+	}
+	if inst&0o100 != 0 { // CLL
+		c.L = 0
+	}
+	if inst&0o40 != 0 { // CMA
+		c.AC ^= 0o7777
+	}
+	if inst&0o20 != 0 { // CML
+		c.L ^= 1
+	}
+	if inst&0o1 != 0 { // IAC
+		c.AC++
+		if c.AC > 0o7777 {
+			c.L ^= 1
+		}
+		c.AC &= 0o7777
+	}
+
+	// Rotates, bit 1 doubles the rotate or swaps bytes without one
+	rotates := 1
+	if inst&0o2 != 0 {
+		rotates = 2
+	}
+	switch inst & 0o16 {
+	case 0o2: // BSW
+		c.AC = (c.AC&0o77)<<6 | c.AC>>6
+	case 0o4, 0o6: // RAL, RTL
+		for i := 0; i < rotates; i++ {
+			lac := c.L<<12 | c.AC
+			lac = (lac<<1 | lac>>12) & 0o17777
+			c.L, c.AC = lac>>12, lac&0o7777
+		}
+	case 0o10, 0o12: // RAR, RTR
+		for i := 0; i < rotates; i++ {
+			lac := c.L<<12 | c.AC
+			lac = (lac>>1 | (lac&1)<<12) & 0o17777
+			c.L, c.AC = lac>>12, lac&0o7777
+		}
+	}
+}
+
+// Group 2 operate microinstructions
+func (c *CPU) executeGroup2(inst int) {
+	var cond bool
+	if inst&0o100 != 0 && c.AC&0o4000 != 0 { // SMA
+		cond = true
+	}
+	if inst&0o40 != 0 && c.AC == 0 { // SZA
+		cond = true
+	}
+	if inst&0o20 != 0 && c.L != 0 { // SNL
+		cond = true
+	}
+	if inst&0o10 != 0 { // Reverse the sense of the skip (SPA, SNA, SZL, SKP)
+		cond = !cond
+	}
+	if cond {
+		c.skip()
+	}
+
+	if inst&0o200 != 0 { // CLA
+		c.AC = 0
+	}
+	if inst&0o4 != 0 { // OSR
+		c.AC |= c.SR
+	}
+	if inst&0o2 != 0 { // HLT
+		c.Halted = true
+		c.Reason = "HLT"
+	}
+}
+
+// Group 3 operate microinstructions (MQ register)
+func (c *CPU) executeGroup3(inst int) {
+	if inst&0o200 != 0 { // CLA
+		c.AC = 0
+	}
+	switch inst & 0o120 {
+	case 0o100: // MQA
+		c.AC |= c.MQ
+	case 0o20: // MQL
+		c.MQ = c.AC
+		c.AC = 0
+	case 0o120: // SWP
+		c.AC, c.MQ = c.MQ, c.AC
+	}
+}
+
+func (c *CPU) skip() {
+	c.PC = (c.PC + 1) & 0o7777
+}
+
+// Print the state of the registers
+func (c *CPU) String() string {
+	return fmt.Sprintf("PC=%o.%.4o AC=%.4o L=%o MQ=%.4o DF=%o", c.IF, c.PC, c.AC, c.L, c.MQ, c.DF)
+}